@@ -0,0 +1,292 @@
+package kapi
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/linxlib/config"
+	"github.com/linxlib/kapi/internal"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AdminOption options of the admin router
+type AdminOption struct {
+	// Enable serve pprof, the route table, the config, build info and runtime stats
+	Enable bool `yaml:"enable"`
+	// Port listen on a separate port, 0 serves on the main port
+	Port int `yaml:"port"`
+	// Prefix of admin routes, default /admin
+	Prefix string `yaml:"prefix"`
+	// Token callers send Authorization: Bearer <token>
+	Token string `yaml:"token"`
+	// Username and Password of basic auth
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// AdminAuth decides if a request may use the admin router
+type AdminAuth func(c *gin.Context) bool
+
+// redactPattern config keys whose values are hidden in /config
+var redactPattern = regexp.MustCompile(`(?i)pass|secret|token|key|credential|dsn`)
+
+var (
+	// redactUserinfo the password of user:password@host, with or without a scheme, e.g. postgres://u:p@h or u:p@tcp(h)/db
+	redactUserinfo = regexp.MustCompile(`(^|[\s,;=]|://)([^:/@\s,;=]*):[^@\s/][^@\s]*@`)
+	// redactParam password=... of DSNs and query strings
+	redactParam = regexp.MustCompile(`(?i)\b([\w.-]*(?:pass|pwd|secret|token|key|credential)[\w.-]*)=([^&;\s]+)`)
+)
+
+// SetAdminAuth replace the auth of the admin router, it also decides who gets details of health checks
+//
+//	@param auth
+func (b *KApi) SetAdminAuth(auth AdminAuth) {
+	b.adminAuth = auth
+}
+
+// adminAuthorized check the token or basic auth of server.admin. without them, only local callers are allowed
+func (b *KApi) adminAuthorized(c *gin.Context) bool {
+	if b.adminAuth != nil {
+		return b.adminAuth(c)
+	}
+	opt := b.option.Server.Admin
+	if opt.Token == "" && opt.Username == "" {
+		ip := net.ParseIP(c.RemoteIP())
+		return ip != nil && ip.IsLoopback()
+	}
+	if opt.Token != "" {
+		if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok &&
+			subtle.ConstantTimeCompare([]byte(token), []byte(opt.Token)) == 1 {
+			return true
+		}
+	}
+	// an empty password would let anyone knowing the username in
+	if opt.Username != "" && opt.Password != "" {
+		if user, pass, ok := c.Request.BasicAuth(); ok &&
+			subtle.ConstantTimeCompare([]byte(user), []byte(opt.Username)) == 1 &&
+			subtle.ConstantTimeCompare([]byte(pass), []byte(opt.Password)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *KApi) adminAuthHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !b.adminAuthorized(c) {
+			if b.option.Server.Admin.Username != "" && b.option.Server.Admin.Password != "" {
+				c.Header("WWW-Authenticate", `Basic realm="kapi admin"`)
+			}
+			c.AbortWithStatus(http.StatusUnauthorized)
+		}
+	}
+}
+
+// handleAdmin register the admin routes, on the main engine or on the engine of the admin port
+func (b *KApi) handleAdmin() {
+	opt := b.option.Server.Admin
+	if !opt.Enable {
+		return
+	}
+	engine := b.engine
+	if opt.Port > 0 {
+		engine = gin.New()
+		b.option.trustProxies(engine)
+		engine.Use(b.recovery())
+		b.adminServer = &http.Server{Addr: fmt.Sprintf(":%d", opt.Port), Handler: engine}
+	}
+	prefix := strings.TrimRight(opt.Prefix, "/")
+	if opt.Prefix == "" {
+		prefix = "/admin"
+	}
+	if opt.Username != "" && opt.Password == "" && b.adminAuth == nil {
+		internal.Warnf("admin: server.admin.password is empty, basic auth is refused")
+	}
+	g := engine.Group(prefix, b.adminAuthHandler())
+	g.GET("/debug/pprof/*name", func(c *gin.Context) {
+		switch name := strings.TrimPrefix(c.Param("name"), "/"); name {
+		case "":
+			pprof.Index(c.Writer, c.Request)
+		case "cmdline":
+			pprof.Cmdline(c.Writer, c.Request)
+		case "profile":
+			pprof.Profile(c.Writer, c.Request)
+		case "symbol":
+			pprof.Symbol(c.Writer, c.Request)
+		case "trace":
+			pprof.Trace(c.Writer, c.Request)
+		default:
+			pprof.Handler(name).ServeHTTP(c.Writer, c.Request)
+		}
+	})
+	g.GET("/routes", func(c *gin.Context) {
+		c.JSON(http.StatusOK, b.routeTable())
+	})
+	g.GET("/config", func(c *gin.Context) {
+		var tree any
+		if y := b.option.Get(); y != nil {
+			tree = redact("", y.Get(config.Root).Value())
+		}
+		c.JSON(http.StatusOK, gin.H{"profile": b.option.Profile(), "config": tree})
+	})
+	g.GET("/build", func(c *gin.Context) {
+		c.JSON(http.StatusOK, buildInfo())
+	})
+	g.GET("/runtime", func(c *gin.Context) {
+		c.JSON(http.StatusOK, b.runtimeStats())
+	})
+	if b.option.Server.Metrics.Enable && b.option.Server.Metrics.Admin {
+		g.GET("/metrics", gin.WrapH(b.metrics))
+	}
+	port := opt.Port
+	if port == 0 {
+		port = b.option.Server.Port
+	}
+	internal.Infof("admin: http://%s:%d%s", b.option.intranetIP, port, prefix)
+}
+
+// routeItem a row of /routes
+type routeItem struct {
+	Method  string `json:"method"`
+	Path    string `json:"path"`
+	Handler string `json:"handler"`
+	Summary string `json:"summary,omitempty"`
+}
+
+// routeTable the routes of controllers, then the other routes of the engine
+func (b *KApi) routeTable() []routeItem {
+	var table []routeItem
+	seen := make(map[string]bool)
+	items := b.routeInfo.GetRouteItems()
+	keys := make([]string, 0, len(items))
+	for k := range items {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, item := range items[k] {
+			path := b.option.Server.BasePath + item.RouterPath
+			table = append(table, routeItem{
+				Method:  strings.ToUpper(item.Method),
+				Path:    path,
+				Handler: strings.Replace(item.Key, "/", ".", 1),
+				Summary: item.Summary,
+			})
+			seen[path] = true
+		}
+	}
+	var others []routeItem
+	for _, r := range b.engine.Routes() {
+		if !seen[r.Path] {
+			others = append(others, routeItem{Method: r.Method, Path: r.Path, Handler: r.Handler})
+		}
+	}
+	sort.Slice(others, func(i, j int) bool {
+		return others[i].Path+others[i].Method < others[j].Path+others[j].Method
+	})
+	return append(table, others...)
+}
+
+// redact hide values of keys like password, secret or token, and credentials in values like urls and DSNs
+func redact(key string, v any) any {
+	if key != "" && redactPattern.MatchString(key) {
+		if v == nil {
+			return nil
+		}
+		return "******"
+	}
+	switch m := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(m))
+		for k, child := range m {
+			out[k] = redact(k, child)
+		}
+		return out
+	case map[any]any:
+		out := make(map[string]any, len(m))
+		for k, child := range m {
+			ks := fmt.Sprint(k)
+			out[ks] = redact(ks, child)
+		}
+		return out
+	case []any:
+		out := make([]any, len(m))
+		for i, child := range m {
+			out[i] = redact("", child)
+		}
+		return out
+	case string:
+		return redactString(m)
+	}
+	return v
+}
+
+// redactString hide the password of urls and password=... params in s
+func redactString(s string) string {
+	s = redactUserinfo.ReplaceAllString(s, "$1$2:******@")
+	return redactParam.ReplaceAllString(s, "$1=******")
+}
+
+// buildInfo the variables set at build time
+func buildInfo() gin.H {
+	info := gin.H{
+		"version":     VERSION,
+		"buildTime":   BUILDTIME,
+		"goVersion":   GOVERSION,
+		"buildOS":     BUILDOS,
+		"buildArch":   BUILDARCH,
+		"os":          OS,
+		"arch":        ARCH,
+		"packageName": PACKAGENAME,
+		"runtime":     runtime.Version(),
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		info["module"] = bi.Main.Path
+		info["moduleVersion"] = bi.Main.Version
+	}
+	return info
+}
+
+// runtimeStats goroutines, memory and GC
+func (b *KApi) runtimeStats() gin.H {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	var lastGC string
+	if ms.LastGC > 0 {
+		lastGC = time.Unix(0, int64(ms.LastGC)).Format(time.RFC3339)
+	}
+	return gin.H{
+		"uptime":       time.Since(b.startTime).Round(time.Second).String(),
+		"goroutines":   runtime.NumGoroutine(),
+		"cpus":         runtime.NumCPU(),
+		"gomaxprocs":   runtime.GOMAXPROCS(0),
+		"heapAlloc":    ms.HeapAlloc,
+		"heapInuse":    ms.HeapInuse,
+		"heapObjects":  ms.HeapObjects,
+		"sys":          ms.Sys,
+		"totalAlloc":   ms.TotalAlloc,
+		"numGC":        ms.NumGC,
+		"pauseTotalNs": ms.PauseTotalNs,
+		"lastGC":       lastGC,
+	}
+}
+
+// runAdmin listen on the admin port if it is separate
+func (b *KApi) runAdmin() {
+	if b.adminServer == nil {
+		return
+	}
+	go func() {
+		if err := b.adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			internal.Errorf("admin: %s", err)
+		}
+	}()
+}
@@ -0,0 +1,80 @@
+package kapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestAdminAuthorized(t *testing.T) {
+	tests := []struct {
+		name   string
+		opt    AdminOption
+		remote string
+		header func(r *http.Request)
+		want   bool
+	}{
+		{name: "local without auth", remote: "127.0.0.1:1234", want: true},
+		{name: "remote without auth", remote: "192.0.2.1:1234"},
+		{name: "token", opt: AdminOption{Token: "t"}, remote: "192.0.2.1:1234",
+			header: func(r *http.Request) { r.Header.Set("Authorization", "Bearer t") }, want: true},
+		{name: "wrong token", opt: AdminOption{Token: "t"}, remote: "127.0.0.1:1234",
+			header: func(r *http.Request) { r.Header.Set("Authorization", "Bearer x") }},
+		{name: "basic auth", opt: AdminOption{Username: "u", Password: "p"}, remote: "192.0.2.1:1234",
+			header: func(r *http.Request) { r.SetBasicAuth("u", "p") }, want: true},
+		{name: "wrong password", opt: AdminOption{Username: "u", Password: "p"}, remote: "192.0.2.1:1234",
+			header: func(r *http.Request) { r.SetBasicAuth("u", "") }},
+		{name: "empty password configured", opt: AdminOption{Username: "u"}, remote: "192.0.2.1:1234",
+			header: func(r *http.Request) { r.SetBasicAuth("u", "") }},
+		{name: "empty password configured, local", opt: AdminOption{Username: "u"}, remote: "127.0.0.1:1234"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newTestKApi(t, WithServer(func(s *ServerOption) {
+				s.Admin = tt.opt
+				s.Admin.Enable = true
+			}))
+			k.handleAdmin()
+			req := httptest.NewRequest(http.MethodGet, "/admin/build", nil)
+			req.RemoteAddr = tt.remote
+			if tt.header != nil {
+				tt.header(req)
+			}
+			w := serve(k, req)
+			if got := w.Code == http.StatusOK; got != tt.want {
+				t.Errorf("authorized = %v (status %d), want %v", got, w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedact(t *testing.T) {
+	in := map[string]any{
+		"db": map[string]any{
+			"password": "p",
+			"url":      "postgres://user:s3cret@db:5432/app?sslmode=disable",
+			"mysql":    "root:s3cret@tcp(127.0.0.1:3306)/app",
+			"pg":       "host=db user=app password=s3cret dbname=app",
+			"query":    "https://api.example.com/v1?api_key=abc&page=2",
+			"plain":    "http://example.com:8080/path",
+		},
+		"servers": []any{"redis://:s3cret@cache:6379/0", 1},
+		"apiKey":  nil,
+	}
+	want := map[string]any{
+		"db": map[string]any{
+			"password": "******",
+			"url":      "postgres://user:******@db:5432/app?sslmode=disable",
+			"mysql":    "root:******@tcp(127.0.0.1:3306)/app",
+			"pg":       "host=db user=app password=****** dbname=app",
+			"query":    "https://api.example.com/v1?api_key=******&page=2",
+			"plain":    "http://example.com:8080/path",
+		},
+		"servers": []any{"redis://:******@cache:6379/0", 1},
+		"apiKey":  nil,
+	}
+	if got := redact("", in); !reflect.DeepEqual(got, want) {
+		t.Errorf("redact() = %v\nwant %v", got, want)
+	}
+}
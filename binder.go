@@ -0,0 +1,314 @@
+package kapi
+
+import (
+	"errors"
+	binding2 "github.com/gin-gonic/gin/binding"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// Binder binds one source of the request (header, query, body...) into the request param.
+// a Binder only maps values, validation runs once after all binders are done
+type Binder interface {
+	// Name of the binder, used to reorder or remove it
+	Name() string
+	Bind(c *Context, obj any) error
+}
+
+// BinderFunc wraps a function as a Binder
+type BinderFunc struct {
+	name string
+	f    func(c *Context, obj any) error
+}
+
+// NewBinder create a Binder from a function
+//
+//	@param name name of the binder
+//	@param f bind function
+//
+//	@return Binder
+func NewBinder(name string, f func(c *Context, obj any) error) Binder {
+	return &BinderFunc{name: name, f: f}
+}
+
+func (b *BinderFunc) Name() string {
+	return b.name
+}
+
+func (b *BinderFunc) Bind(c *Context, obj any) error {
+	return b.f(c, obj)
+}
+
+var (
+	// HeaderBinder binds fields with `header` tag
+	HeaderBinder Binder = headerBinder{}
+	// UriBinder binds fields with `uri` tag
+	UriBinder Binder = uriBinder{}
+	// PathBinder binds fields with `path` tag
+	PathBinder Binder = pathBinder{}
+	// QueryBinder binds fields with `query` tag
+	QueryBinder Binder = queryBinder{}
+	// PageBinder fills PageQuery fields from query
+	PageBinder Binder = pageBinder{}
+	// CookieBinder binds fields with `cookie` tag. not in DefaultBinders
+	CookieBinder Binder = cookieBinder{}
+	// FormBinder binds fields with `form` tag from urlencoded or multipart form.
+	// multipart forms are streamed if the request param has *UploadedFile fields
+	FormBinder Binder = formBinder{}
+	// BodyBinder binds body with the codec of Content-Type
+	BodyBinder Binder = bodyBinder{}
+)
+
+// DefaultBinders returns the default binder order: header → uri → path → query → page → form → body
+//
+//	@return []Binder
+func DefaultBinders() []Binder {
+	return []Binder{HeaderBinder, UriBinder, PathBinder, QueryBinder, PageBinder, FormBinder, BodyBinder}
+}
+
+type headerBinder struct{}
+
+func (headerBinder) Name() string {
+	return "header"
+}
+
+func (headerBinder) Bind(c *Context, obj any) error {
+	m := make(map[string][]string)
+	walkTaggedFields(obj, "header", func(name string, _ reflect.Value, _ reflect.StructField) {
+		if v := c.Request.Header.Values(name); len(v) > 0 {
+			m[name] = v
+		}
+	})
+	return binding2.MapFormWithTag(obj, m, "header")
+}
+
+type cookieBinder struct{}
+
+func (cookieBinder) Name() string {
+	return "cookie"
+}
+
+func (cookieBinder) Bind(c *Context, obj any) error {
+	m := make(map[string][]string)
+	walkTaggedFields(obj, "cookie", func(name string, _ reflect.Value, _ reflect.StructField) {
+		if v, err := c.Request.Cookie(name); err == nil {
+			m[name] = []string{v.Value}
+		}
+	})
+	return binding2.MapFormWithTag(obj, m, "cookie")
+}
+
+type uriBinder struct{}
+
+func (uriBinder) Name() string {
+	return "uri"
+}
+
+func (uriBinder) Bind(c *Context, obj any) error {
+	return binding2.MapFormWithTag(obj, paramsMap(c), "uri")
+}
+
+type pathBinder struct{}
+
+func (pathBinder) Name() string {
+	return "path"
+}
+
+func (pathBinder) Bind(c *Context, obj any) error {
+	return binding2.MapFormWithTag(obj, paramsMap(c), "path")
+}
+
+func paramsMap(c *Context) map[string][]string {
+	m := make(map[string][]string, len(c.Params))
+	for _, v := range c.Params {
+		m[v.Key] = []string{v.Value}
+	}
+	return m
+}
+
+type queryBinder struct{}
+
+func (queryBinder) Name() string {
+	return "query"
+}
+
+func (queryBinder) Bind(c *Context, obj any) error {
+	return binding2.MapFormWithTag(obj, c.Request.URL.Query(), "query")
+}
+
+type formBinder struct{}
+
+func (formBinder) Name() string {
+	return "form"
+}
+
+func (formBinder) Bind(c *Context, obj any) error {
+	switch c.ContentType() {
+	case binding2.MIMEMultipartPOSTForm:
+		if hasUploadFields(obj) {
+			return c.kapi.streamMultipart(c, obj)
+		}
+		form, err := c.MultipartForm()
+		if err != nil {
+			return err
+		}
+		if err := binding2.MapFormWithTag(obj, form.Value, "form"); err != nil {
+			return err
+		}
+		return mapFormFiles(obj, form.File)
+	case binding2.MIMEPOSTForm:
+		if err := c.Request.ParseForm(); err != nil {
+			return err
+		}
+		return binding2.MapFormWithTag(obj, c.Request.PostForm, "form")
+	}
+	return nil
+}
+
+// mapFormFiles set multipart.FileHeader fields with `form` tag
+func mapFormFiles(obj any, files map[string][]*multipart.FileHeader) error {
+	walkTaggedFields(obj, "form", func(name string, field reflect.Value, _ reflect.StructField) {
+		fhs := files[name]
+		if len(fhs) == 0 {
+			return
+		}
+		switch field.Interface().(type) {
+		case *multipart.FileHeader:
+			field.Set(reflect.ValueOf(fhs[0]))
+		case multipart.FileHeader:
+			field.Set(reflect.ValueOf(*fhs[0]))
+		case []*multipart.FileHeader:
+			field.Set(reflect.ValueOf(fhs))
+		case []multipart.FileHeader:
+			s := make([]multipart.FileHeader, len(fhs))
+			for i, fh := range fhs {
+				s[i] = *fh
+			}
+			field.Set(reflect.ValueOf(s))
+		}
+	})
+	return nil
+}
+
+type bodyBinder struct{}
+
+func (bodyBinder) Name() string {
+	return "body"
+}
+
+func (bodyBinder) Bind(c *Context, obj any) error {
+	if !hasBody(c.Request) {
+		return nil
+	}
+	codec := JSONCodec
+	if ct := c.ContentType(); ct != "" {
+		if codec = c.kapi.codecFor(ct); codec == nil {
+			if ct == binding2.MIMEMultipartPOSTForm || ct == binding2.MIMEPOSTForm {
+				// bound by formBinder
+				return nil
+			}
+			return NewStatusError(http.StatusUnsupportedMediaType, "unsupported Content-Type "+ct)
+		}
+	}
+	if err := codec.Decode(c.Request.Body, obj); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// hasBody GET and HEAD requests are never bound from body
+func hasBody(r *http.Request) bool {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return false
+	}
+	return r.Body != nil && r.Body != http.NoBody
+}
+
+// walkTaggedFields call fn with every settable struct field which has the tag, embedded structs included
+func walkTaggedFields(obj any, tag string, fn func(name string, field reflect.Value, sf reflect.StructField)) {
+	v := reflect.Indirect(reflect.ValueOf(obj))
+	if v.Kind() != reflect.Struct {
+		return
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		field := v.Field(i)
+		name, ok := sf.Tag.Lookup(tag)
+		if !ok {
+			if sf.Anonymous {
+				if field.Kind() == reflect.Ptr {
+					if field.IsNil() {
+						continue
+					}
+					field = field.Elem()
+				}
+				if field.Kind() == reflect.Struct && field.CanAddr() {
+					walkTaggedFields(field.Addr().Interface(), tag, fn)
+				}
+			}
+			continue
+		}
+		name, _, _ = strings.Cut(name, ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		fn(name, field, sf)
+	}
+}
+
+// UseBinders replace the binder pipeline
+//
+//	@param binders binders in order
+func (b *KApi) UseBinders(binders ...Binder) {
+	b.binders = binders
+}
+
+// AddBinder append a binder to the end of the binder pipeline
+//
+//	@param binder
+func (b *KApi) AddBinder(binder Binder) {
+	b.binders = append(b.binders, binder)
+}
+
+// AddBinderBefore insert a binder before the binder named name. appends if not found
+//
+//	@param name name of an existing binder
+//	@param binder
+func (b *KApi) AddBinderBefore(name string, binder Binder) {
+	for i, v := range b.binders {
+		if v.Name() == name {
+			b.binders = append(b.binders[:i], append([]Binder{binder}, b.binders[i:]...)...)
+			return
+		}
+	}
+	b.AddBinder(binder)
+}
+
+// RemoveBinder remove the binder named name from the binder pipeline
+//
+//	@param name
+func (b *KApi) RemoveBinder(name string) {
+	for i, v := range b.binders {
+		if v.Name() == name {
+			b.binders = append(b.binders[:i], b.binders[i+1:]...)
+			return
+		}
+	}
+}
+
+// Binders returns the binders in order
+//
+//	@return []Binder
+func (b *KApi) Binders() []Binder {
+	return b.binders
+}
@@ -0,0 +1,117 @@
+package kapi
+
+import (
+	"github.com/go-playground/validator/v10"
+	binding3 "github.com/linxlib/binding"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// validations counts checks of the kapi_counted rule
+var validations atomic.Int32
+
+func init() {
+	v := binding3.Validator.Engine().(*validator.Validate)
+	_ = v.RegisterValidation("kapi_counted", func(fl validator.FieldLevel) bool {
+		validations.Add(1)
+		return fl.Field().String() != ""
+	})
+}
+
+type binderReq struct {
+	ID   string `path:"id" binding:"kapi_counted"`
+	Name string `query:"name" json:"name"`
+}
+
+// recordBinder records its name into the Name of binderReq
+func recordBinder(name string) Binder {
+	return NewBinder(name, func(c *Context, obj any) error {
+		req := obj.(*binderReq)
+		req.Name += name + ","
+		return nil
+	})
+}
+
+func TestBinderPipeline(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(k *KApi)
+		path  string
+		body  string
+		want  string // binderReq.Name after binding
+	}{
+		{
+			name: "query then body",
+			path: "/items/1?name=query",
+			body: `{"name":"body"}`,
+			want: "body",
+		},
+		{
+			name:  "body removed",
+			setup: func(k *KApi) { k.RemoveBinder("body") },
+			path:  "/items/1?name=query",
+			body:  `{"name":"body"}`,
+			want:  "query",
+		},
+		{
+			name: "custom binders in order",
+			setup: func(k *KApi) {
+				k.UseBinders(PathBinder, recordBinder("a"), recordBinder("c"))
+				k.AddBinderBefore("c", recordBinder("b"))
+				k.AddBinderBefore("missing", recordBinder("d"))
+			},
+			path: "/items/1",
+			want: "a,b,c,d,",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newTestKApi(t)
+			if tt.setup != nil {
+				tt.setup(k)
+			}
+			var got string
+			k.route(http.MethodPost, "/items/:id", nil, func(c *Context, req *binderReq) (any, error) {
+				got = req.Name
+				return "ok", nil
+			})
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			validations.Store(0)
+			w := serve(k, req)
+			if got != tt.want {
+				t.Errorf("Name = %q, want %q: %s", got, tt.want, w.Body)
+			}
+			if n := validations.Load(); n != 1 {
+				t.Errorf("validated %d times, want once", n)
+			}
+		})
+	}
+}
+
+func TestBinderValidationFails(t *testing.T) {
+	k := newTestKApi(t)
+	// the id is not bound, so validation fails once after every binder ran
+	k.UseBinders(QueryBinder, BodyBinder)
+	called := false
+	k.route(http.MethodPost, "/items/:id", nil, func(c *Context, req *binderReq) (any, error) {
+		called = true
+		return "ok", nil
+	})
+	validations.Store(0)
+	w := serve(k, httptest.NewRequest(http.MethodPost, "/items/1?name=a", nil))
+	if called {
+		t.Error("handler called with an invalid request")
+	}
+	if n := validations.Load(); n != 1 {
+		t.Errorf("validated %d times, want once", n)
+	}
+	if !strings.Contains(w.Body.String(), "kapi_counted") {
+		t.Errorf("body = %s, want the failed rule", w.Body)
+	}
+}
@@ -0,0 +1,385 @@
+package kapi
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/linxlib/kapi/internal"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CachedResponse a response of @CACHE
+type CachedResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+	ETag   string
+	Stored time.Time
+}
+
+// ResponseCache stores responses of @CACHE, the default is an LRU in memory.
+// implement it on redis or memcached to share responses between instances
+type ResponseCache interface {
+	// Get returns nil if key is missing or expired
+	Get(ctx context.Context, key string) (*CachedResponse, error)
+	Set(ctx context.Context, key string, r *CachedResponse, ttl time.Duration) error
+	// DeletePrefix remove the responses whose key starts with prefix. keys start with the path of the request
+	DeletePrefix(ctx context.Context, prefix string) error
+}
+
+// MemoryResponseCache an LRU of responses in memory
+type MemoryResponseCache struct {
+	lock    sync.Mutex
+	max     int
+	entries map[string]*list.Element
+	lru     *list.List // front is the most recently used
+}
+
+type cacheEntry struct {
+	key     string
+	r       *CachedResponse
+	expires time.Time
+}
+
+// NewMemoryResponseCache create an LRU of responses in memory
+//
+//	@param max the least recently used response is dropped beyond max, default 1024
+//
+//	@return *MemoryResponseCache
+func NewMemoryResponseCache(max int) *MemoryResponseCache {
+	if max <= 0 {
+		max = 1024
+	}
+	return &MemoryResponseCache{max: max, entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+func (m *MemoryResponseCache) Get(_ context.Context, key string) (*CachedResponse, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	e, ok := m.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	entry := e.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		m.lru.Remove(e)
+		delete(m.entries, key)
+		return nil, nil
+	}
+	m.lru.MoveToFront(e)
+	return entry.r, nil
+}
+
+func (m *MemoryResponseCache) Set(_ context.Context, key string, r *CachedResponse, ttl time.Duration) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	entry := &cacheEntry{key: key, r: r, expires: time.Now().Add(ttl)}
+	if e, ok := m.entries[key]; ok {
+		e.Value = entry
+		m.lru.MoveToFront(e)
+		return nil
+	}
+	m.entries[key] = m.lru.PushFront(entry)
+	for m.lru.Len() > m.max {
+		e := m.lru.Back()
+		m.lru.Remove(e)
+		delete(m.entries, e.Value.(*cacheEntry).key)
+	}
+	return nil
+}
+
+func (m *MemoryResponseCache) DeletePrefix(_ context.Context, prefix string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for key, e := range m.entries {
+		if strings.HasPrefix(key, prefix) {
+			m.lru.Remove(e)
+			delete(m.entries, key)
+		}
+	}
+	return nil
+}
+
+// SetResponseCache set where the responses of @CACHE are stored
+//
+//	@param cache
+func (b *KApi) SetResponseCache(cache ResponseCache) {
+	b.responseCache = cache
+}
+
+// EvictCache remove the cached responses of paths, with any query. paths include the base path, e.g. /api/v1/users/1
+//
+//	@param ctx
+//	@param paths
+//
+//	@return error
+func (b *KApi) EvictCache(ctx context.Context, paths ...string) error {
+	for _, path := range paths {
+		if err := b.responseCache.DeletePrefix(ctx, path+"?"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EvictCachePrefix remove the cached responses of paths starting with prefix, e.g. /api/v1/users
+//
+//	@param ctx
+//	@param prefix
+//
+//	@return error
+func (b *KApi) EvictCachePrefix(ctx context.Context, prefix string) error {
+	return b.responseCache.DeletePrefix(ctx, prefix)
+}
+
+// CacheInvalidator evicts cached responses in the context of a request, inject it into write endpoints:
+//
+//	func (u *User) Update(c *kapi.Context, req *UpdateUser, cache *kapi.CacheInvalidator) error {
+//		...
+//		return cache.Evict("/api/v1/users/" + req.ID)
+//	}
+type CacheInvalidator struct {
+	k   *KApi
+	ctx context.Context
+}
+
+// Evict see KApi.EvictCache
+//
+//	@param paths
+//
+//	@return error
+func (i *CacheInvalidator) Evict(paths ...string) error {
+	return i.k.EvictCache(i.ctx, paths...)
+}
+
+// EvictPrefix see KApi.EvictCachePrefix
+//
+//	@param prefix
+//
+//	@return error
+func (i *CacheInvalidator) EvictPrefix(prefix string) error {
+	return i.k.EvictCachePrefix(i.ctx, prefix)
+}
+
+// cachePolicy a parsed @CACHE, e.g. 30s vary=Authorization
+type cachePolicy struct {
+	ttl  time.Duration
+	vary []string // request headers the response depends on, Accept is always included
+	auth bool     // the controller has HeaderAuth
+}
+
+// parseCache parse @CACHE. vary may be repeated or comma separated
+//
+//	@param s
+//
+//	@return *cachePolicy nil if s is empty
+//	@return error
+func parseCache(s string) (*cachePolicy, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	ttl, err := time.ParseDuration(fields[0])
+	if err != nil || ttl < time.Second {
+		return nil, fmt.Errorf("@CACHE %s: want a duration of at least 1s like 30s", s)
+	}
+	p := &cachePolicy{ttl: ttl, vary: []string{"Accept"}}
+	for _, f := range fields[1:] {
+		vary, ok := strings.CutPrefix(f, "vary=")
+		if !ok || vary == "" {
+			return nil, fmt.Errorf("@CACHE %s: unknown option %s", s, f)
+		}
+		for _, h := range strings.Split(vary, ",") {
+			if h = http.CanonicalHeaderKey(strings.TrimSpace(h)); h != "" && h != "Accept" {
+				p.vary = append(p.vary, h)
+			}
+		}
+	}
+	return p, nil
+}
+
+// private responses depending on credentials or of routes with HeaderAuth must not be stored by shared caches
+func (p *cachePolicy) private() bool {
+	if p.auth {
+		return true
+	}
+	for _, h := range p.vary {
+		if h == "Authorization" || h == "Cookie" {
+			return true
+		}
+	}
+	return false
+}
+
+// key the path and query of the request, then a hash of the vary headers
+func (p *cachePolicy) key(c *Context) string {
+	h := sha256.New()
+	for _, name := range p.vary {
+		_, _ = fmt.Fprintf(h, "%s=%s\n", name, c.GetHeader(name))
+	}
+	return c.Request.URL.Path + "?" + c.Request.URL.Query().Encode() + "#" + hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// setHeaders ETag, Cache-Control and Vary of a cached response. Vary is merged with the one of the
+// response, e.g. Origin of CORS
+func (p *cachePolicy) setHeaders(c *Context, r *CachedResponse) {
+	c.Header("ETag", r.ETag)
+	maxAge := p.ttl - time.Since(r.Stored)
+	cacheControl := "public"
+	if p.private() {
+		cacheControl = "private"
+	}
+	c.Header("Cache-Control", fmt.Sprintf("%s, max-age=%d", cacheControl, max(ceilSeconds(maxAge), 0)))
+	c.Header("Vary", mergeVary(c.Writer.Header().Values("Vary"), r.Header.Values("Vary"), p.vary))
+}
+
+// mergeVary join header names of Vary values without duplicates
+func mergeVary(values ...[]string) string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, v := range values {
+		for _, line := range v {
+			for _, name := range strings.Split(line, ",") {
+				name = strings.TrimSpace(name)
+				key := http.CanonicalHeaderKey(name)
+				if name == "" || seen[key] {
+					continue
+				}
+				seen[key] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// storedHeader whether a response header is kept in the cache. headers of this request only,
+// Access-Control-* of CORS depend on its Origin and are set again by CORS
+func storedHeader(name string) bool {
+	switch name {
+	case HeaderRequestID, "Retry-After", "RateLimit-Policy", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset":
+		return false
+	}
+	return !strings.HasPrefix(name, "Access-Control-")
+}
+
+// serve respond the cached response of c, or 304 if If-None-Match matches its ETag
+//
+//	@return bool false if there is no cached response
+func (p *cachePolicy) serve(c *Context, key string) bool {
+	r, err := c.kapi.responseCache.Get(c.Request.Context(), key)
+	if err != nil {
+		internal.Errorf("response cache: %s", err)
+		return false
+	}
+	if r == nil {
+		return false
+	}
+	for name, values := range r.Header {
+		if name != "Vary" {
+			c.Writer.Header()[name] = values
+		}
+	}
+	p.setHeaders(c, r)
+	c.Header("Age", strconv.Itoa(int(time.Since(r.Stored).Seconds())))
+	c.Header("X-Cache", "HIT")
+	if etagMatch(c.GetHeader("If-None-Match"), r.ETag) {
+		c.Status(http.StatusNotModified)
+		return true
+	}
+	c.Data(r.Status, r.Header.Get("Content-Type"), r.Body)
+	return true
+}
+
+// buffer responses of the handler into w
+func (p *cachePolicy) buffer(c *Context) *cacheWriter {
+	w := &cacheWriter{ResponseWriter: c.Writer}
+	c.Writer = w
+	return w
+}
+
+// finish store a 200 response without cookies, then write it or 304. other responses are written as is
+func (p *cachePolicy) finish(c *Context, key string, w *cacheWriter) {
+	c.Writer = w.ResponseWriter
+	header := c.Writer.Header()
+	if c.Writer.Status() != http.StatusOK || len(header.Values("Set-Cookie")) > 0 {
+		c.Writer.WriteHeaderNow()
+		_, _ = c.Writer.Write(w.body.Bytes())
+		return
+	}
+	sum := sha256.Sum256(w.body.Bytes())
+	r := &CachedResponse{
+		Status: http.StatusOK,
+		Header: make(http.Header),
+		Body:   w.body.Bytes(),
+		ETag:   `"` + hex.EncodeToString(sum[:16]) + `"`,
+		Stored: time.Now(),
+	}
+	for name, values := range header {
+		if storedHeader(name) {
+			r.Header[name] = values
+		}
+	}
+	if err := c.kapi.responseCache.Set(c.Request.Context(), key, r, p.ttl); err != nil {
+		internal.Errorf("response cache: %s", err)
+	}
+	p.setHeaders(c, r)
+	c.Header("X-Cache", "MISS")
+	if etagMatch(c.GetHeader("If-None-Match"), r.ETag) {
+		c.Writer.WriteHeader(http.StatusNotModified)
+		c.Writer.WriteHeaderNow()
+		return
+	}
+	c.Writer.WriteHeaderNow()
+	_, _ = c.Writer.Write(r.Body)
+}
+
+// etagMatch weak comparison of If-None-Match
+func etagMatch(ifNoneMatch string, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheWriter buffers the response, so the ETag can be set and 304 responded after the handler
+type cacheWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *cacheWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *cacheWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+// WriteHeaderNow the header is written in finish
+func (w *cacheWriter) WriteHeaderNow() {}
+
+func (w *cacheWriter) Written() bool {
+	return w.body.Len() > 0
+}
+
+func (w *cacheWriter) Size() int {
+	if w.body.Len() == 0 {
+		return -1
+	}
+	return w.body.Len()
+}
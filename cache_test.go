@@ -0,0 +1,122 @@
+package kapi
+
+import (
+	"github.com/linxlib/kapi/internal/cors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// authController a HeaderAuth letting every request through
+type authController struct{}
+
+func (authController) HeaderAuth(c *Context) {}
+
+// newCachedKApi create a KApi with a cached GET /items of Test.Get, calls counts calls of the handler
+func newCachedKApi(t *testing.T, cache string, controller any, calls *int) *KApi {
+	t.Helper()
+	k := newTestKApi(t)
+	err := k.registerMethodToRouter(RouteItem{Method: "GET", RouterPath: "/items", Cache: cache}, "Test.Get", controller,
+		func(c *Context) (any, error) {
+			*calls++
+			c.Writer.Header().Add("Vary", "Accept-Language")
+			return "ok", nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestCacheCORS(t *testing.T) {
+	var calls int
+	k := newCachedKApi(t, "30s", nil, &calls)
+	c := cors.DefaultConfig()
+	c.AllowAllOrigins = false
+	c.AllowOrigins = []string{"https://a.example", "https://b.example"}
+	k.option.setCors(c)
+	tests := []struct {
+		origin string
+		xCache string
+	}{
+		{"https://a.example", "MISS"},
+		{"https://b.example", "HIT"},
+		{"", "HIT"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/items", nil)
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		w := serve(k, req)
+		if w.Code != http.StatusOK || w.Header().Get("X-Cache") != tt.xCache {
+			t.Fatalf("origin %q: status = %d, X-Cache = %s, want %s", tt.origin, w.Code, w.Header().Get("X-Cache"), tt.xCache)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.origin {
+			t.Errorf("origin %q: Access-Control-Allow-Origin = %q", tt.origin, got)
+		}
+		if got := w.Header().Values("Vary"); len(got) != 1 || got[0] != "Origin, Accept-Language, Accept" {
+			t.Errorf("origin %q: Vary = %q", tt.origin, got)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("handler called %d times", calls)
+	}
+}
+
+func TestCacheControl(t *testing.T) {
+	tests := []struct {
+		name       string
+		cache      string
+		controller any
+		want       string
+	}{
+		{"public", "30s", nil, "public, max-age=30"},
+		{"vary by Authorization", "30s vary=Authorization", nil, "private, max-age=30"},
+		{"vary by Cookie", "30s vary=cookie", nil, "private, max-age=30"},
+		{"HeaderAuth", "30s", authController{}, "private, max-age=30"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			k := newCachedKApi(t, tt.cache, tt.controller, &calls)
+			for _, xCache := range []string{"MISS", "HIT"} {
+				w := serve(k, httptest.NewRequest(http.MethodGet, "/items", nil))
+				if w.Header().Get("X-Cache") != xCache {
+					t.Fatalf("X-Cache = %s, want %s", w.Header().Get("X-Cache"), xCache)
+				}
+				if got := w.Header().Get("Cache-Control"); got != tt.want {
+					t.Errorf("%s: Cache-Control = %q, want %q", xCache, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheNotModified(t *testing.T) {
+	var calls int
+	k := newCachedKApi(t, "30s", nil, &calls)
+	w := serve(k, httptest.NewRequest(http.MethodGet, "/items", nil))
+	etag := w.Header().Get("ETag")
+	tests := []struct {
+		ifNoneMatch string
+		status      int
+	}{
+		{etag, http.StatusNotModified},
+		{"W/" + etag, http.StatusNotModified},
+		{`"other", ` + etag, http.StatusNotModified},
+		{"*", http.StatusNotModified},
+		{`"other"`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/items", nil)
+		req.Header.Set("If-None-Match", tt.ifNoneMatch)
+		if w := serve(k, req); w.Code != tt.status {
+			t.Errorf("If-None-Match %s: status = %d, want %d", tt.ifNoneMatch, w.Code, tt.status)
+		}
+	}
+	if !strings.HasPrefix(etag, `"`) || calls != 1 {
+		t.Errorf("ETag = %s, handler called %d times", etag, calls)
+	}
+}
@@ -0,0 +1,270 @@
+package kapi
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	binding2 "github.com/gin-gonic/gin/binding"
+	"github.com/gin-gonic/gin/render"
+	"github.com/ugorji/go/codec"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+	"io"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Codec decodes request body and renders response body of some media types
+type Codec interface {
+	// MediaTypes the first one is used in Content-Type and consumes/produces of the doc
+	MediaTypes() []string
+	Decode(r io.Reader, obj any) error
+	Render(obj any) render.Render
+}
+
+var (
+	// JSONCodec application/json. the default codec
+	JSONCodec Codec = jsonCodec{}
+	// XMLCodec application/xml
+	XMLCodec Codec = xmlCodec{}
+	// YAMLCodec application/x-yaml
+	YAMLCodec Codec = yamlCodec{}
+	// MsgPackCodec application/x-msgpack
+	MsgPackCodec Codec = msgPackCodec{}
+	// ProtoBufCodec application/x-protobuf. not in DefaultCodecs, request types must be proto.Message.
+	// the data of the result is rendered, it's only negotiated if the data is a proto.Message
+	ProtoBufCodec Codec = protoBufCodec{}
+)
+
+// DefaultCodecs returns the default codecs, JSON first
+//
+//	@return []Codec
+func DefaultCodecs() []Codec {
+	return []Codec{JSONCodec, XMLCodec, YAMLCodec, MsgPackCodec}
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) MediaTypes() []string {
+	return []string{binding2.MIMEJSON}
+}
+
+func (jsonCodec) Decode(r io.Reader, obj any) error {
+	decoder := json.NewDecoder(r)
+	if binding2.EnableDecoderUseNumber {
+		decoder.UseNumber()
+	}
+	if binding2.EnableDecoderDisallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+	return decoder.Decode(obj)
+}
+
+func (jsonCodec) Render(obj any) render.Render {
+	return render.PureJSON{Data: obj}
+}
+
+type xmlCodec struct{}
+
+func (xmlCodec) MediaTypes() []string {
+	return []string{binding2.MIMEXML, binding2.MIMEXML2}
+}
+
+func (xmlCodec) Decode(r io.Reader, obj any) error {
+	return xml.NewDecoder(r).Decode(obj)
+}
+
+func (xmlCodec) Render(obj any) render.Render {
+	return render.XML{Data: obj}
+}
+
+type yamlCodec struct{}
+
+func (yamlCodec) MediaTypes() []string {
+	return []string{binding2.MIMEYAML, "application/yaml", "text/yaml"}
+}
+
+func (yamlCodec) Decode(r io.Reader, obj any) error {
+	return yaml.NewDecoder(r).Decode(obj)
+}
+
+func (yamlCodec) Render(obj any) render.Render {
+	return render.YAML{Data: obj}
+}
+
+type msgPackCodec struct{}
+
+func (msgPackCodec) MediaTypes() []string {
+	return []string{binding2.MIMEMSGPACK, binding2.MIMEMSGPACK2, "application/vnd.msgpack"}
+}
+
+func (msgPackCodec) Decode(r io.Reader, obj any) error {
+	return codec.NewDecoder(r, new(codec.MsgpackHandle)).Decode(obj)
+}
+
+func (msgPackCodec) Render(obj any) render.Render {
+	return render.MsgPack{Data: obj}
+}
+
+type protoBufCodec struct{}
+
+func (protoBufCodec) MediaTypes() []string {
+	return []string{binding2.MIMEPROTOBUF, "application/protobuf"}
+}
+
+func (protoBufCodec) Decode(r io.Reader, obj any) error {
+	msg, ok := obj.(proto.Message)
+	if !ok {
+		return errors.New("request type is not a proto.Message")
+	}
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(buf, msg)
+}
+
+func (protoBufCodec) Render(obj any) render.Render {
+	return render.ProtoBuf{Data: protoMessage(obj)}
+}
+
+func (protoBufCodec) canRender(obj any) bool {
+	return protoMessage(obj) != nil
+}
+
+// protoMessage the proto.Message of obj or of the data of a result, nil if there is none
+func protoMessage(obj any) proto.Message {
+	if body, ok := obj.(messageBody); ok {
+		obj = body.Data
+	}
+	msg, _ := obj.(proto.Message)
+	return msg
+}
+
+// renderChecker implemented by codecs which can only render some types
+type renderChecker interface {
+	canRender(obj any) bool
+}
+
+// canRender check if c can render obj
+func canRender(c Codec, obj any) bool {
+	if rc, ok := c.(renderChecker); ok {
+		return rc.canRender(obj)
+	}
+	return true
+}
+
+// UseCodecs replace all codecs, the first one is the default
+//
+//	@param codecs
+func (b *KApi) UseCodecs(codecs ...Codec) {
+	b.codecs = codecs
+}
+
+// RegisterCodec add a codec, or replace the codec which has the same first media type
+//
+//	@param c
+func (b *KApi) RegisterCodec(c Codec) {
+	for i, v := range b.codecs {
+		if v.MediaTypes()[0] == c.MediaTypes()[0] {
+			b.codecs[i] = c
+			return
+		}
+	}
+	b.codecs = append(b.codecs, c)
+}
+
+// Codecs returns all registered codecs
+//
+//	@return []Codec
+func (b *KApi) Codecs() []Codec {
+	return b.codecs
+}
+
+// mediaTypes the first media type of every codec
+func (b *KApi) mediaTypes() []string {
+	mts := make([]string, 0, len(b.codecs))
+	for _, v := range b.codecs {
+		mts = append(mts, v.MediaTypes()[0])
+	}
+	return mts
+}
+
+// codecFor find the codec for Content-Type. nil if not supported
+func (b *KApi) codecFor(contentType string) Codec {
+	for _, v := range b.codecs {
+		for _, mt := range v.MediaTypes() {
+			if strings.EqualFold(mt, contentType) {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+type acceptItem struct {
+	mediaType string
+	q         float64
+}
+
+// negotiate select a codec which can render obj by Accept header. the default codec is used if Accept
+// is empty or from browser navigation
+//
+//	@param accept
+//	@param obj
+//
+//	@return Codec
+//	@return bool false if no codec satisfies Accept, the default codec is returned then
+func (b *KApi) negotiate(accept string, obj any) (Codec, bool) {
+	// browser navigation also accepts xml, keep the default for it
+	if accept == "" || strings.Contains(accept, "text/html") {
+		return b.defaultCodec(obj), true
+	}
+	items := make([]acceptItem, 0)
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if q > 0 {
+			items = append(items, acceptItem{mediaType: mt, q: q})
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].q > items[j].q
+	})
+	for _, item := range items {
+		for _, v := range b.codecs {
+			if !canRender(v, obj) {
+				continue
+			}
+			if item.mediaType == "*/*" {
+				return v, true
+			}
+			for _, mt := range v.MediaTypes() {
+				if mt == item.mediaType || (strings.HasSuffix(item.mediaType, "/*") && strings.HasPrefix(mt, strings.TrimSuffix(item.mediaType, "*"))) {
+					return v, true
+				}
+			}
+		}
+	}
+	return b.defaultCodec(obj), false
+}
+
+// defaultCodec the first codec which can render obj, JSON if there is none
+func (b *KApi) defaultCodec(obj any) Codec {
+	for _, v := range b.codecs {
+		if canRender(v, obj) {
+			return v
+		}
+	}
+	return JSONCodec
+}
@@ -0,0 +1,119 @@
+package kapi
+
+import (
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	k := &KApi{codecs: append(DefaultCodecs(), ProtoBufCodec)}
+	msg := wrapperspb.String("hi")
+	tests := []struct {
+		name   string
+		accept string
+		obj    any
+		want   string
+		ok     bool
+	}{
+		{"empty", "", 1, "application/json", true},
+		{"browser", "text/html,application/xml;q=0.9,*/*;q=0.8", 1, "application/json", true},
+		{"any", "*/*", 1, "application/json", true},
+		{"xml", "application/xml", 1, "application/xml", true},
+		{"quality", "application/json;q=0.5, application/x-yaml", 1, "application/x-yaml", true},
+		{"wildcard subtype", "application/*", 1, "application/json", true},
+		{"unsupported", "text/csv", 1, "application/json", false},
+		{"zero quality", "application/xml;q=0", 1, "application/json", false},
+		{"protobuf of message", "application/x-protobuf", messageBody{Data: msg}, "application/x-protobuf", true},
+		{"protobuf of struct", "application/x-protobuf", messageBody{Data: 1}, "application/json", false},
+		{"protobuf or json", "application/x-protobuf, application/json;q=0.5", messageBody{Data: 1}, "application/json", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, ok := k.negotiate(tt.accept, tt.obj)
+			if got := c.MediaTypes()[0]; got != tt.want || ok != tt.ok {
+				t.Errorf("negotiate(%q) = %s, %v, want %s, %v", tt.accept, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestNegotiateProtoBufFirst(t *testing.T) {
+	k := &KApi{codecs: []Codec{ProtoBufCodec, JSONCodec}}
+	if c, _ := k.negotiate("", messageBody{Msg: "failed"}); c != JSONCodec {
+		t.Errorf("default codec of a non proto result = %s, want application/json", c.MediaTypes()[0])
+	}
+}
+
+type codecReq struct {
+	Name string `json:"name" form:"name"`
+}
+
+func TestRespond(t *testing.T) {
+	k := newTestKApi(t)
+	k.RegisterCodec(ProtoBufCodec)
+	k.route(http.MethodGet, "/data", nil, func(c *Context) (any, error) {
+		return map[string]string{"name": "kapi"}, nil
+	})
+	k.route(http.MethodGet, "/proto", nil, func(c *Context) (any, error) {
+		return wrapperspb.String("kapi"), nil
+	})
+	k.route(http.MethodPost, "/echo", nil, func(c *Context, req *codecReq) (any, error) {
+		return req.Name, nil
+	})
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		accept      string
+		status      int
+		produces    string
+	}{
+		{"json", "GET", "/data", "", "", "application/json", 200, "application/json"},
+		{"yaml", "GET", "/data", "", "", "application/x-yaml", 200, "application/x-yaml"},
+		{"not acceptable", "GET", "/data", "", "", "text/csv", 406, "application/json"},
+		{"protobuf of struct", "GET", "/data", "", "", "application/x-protobuf", 406, "application/json"},
+		{"protobuf", "GET", "/proto", "", "", "application/x-protobuf", 200, "application/x-protobuf"},
+		{"json body", "POST", "/echo", "application/json", `{"name":"kapi"}`, "", 200, "application/json"},
+		{"form body", "POST", "/echo", "application/x-www-form-urlencoded", "name=kapi", "", 200, "application/json"},
+		{"unsupported body", "POST", "/echo", "text/plain", "kapi", "", 415, "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := serve(k, req)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, tt.produces) {
+				t.Errorf("Content-Type = %s, want %s", ct, tt.produces)
+			}
+			if tt.status == 200 && tt.produces == "application/json" && !strings.Contains(w.Body.String(), "kapi") {
+				t.Errorf("body = %s", w.Body)
+			}
+		})
+	}
+}
+
+func TestRenderProtoBuf(t *testing.T) {
+	w := httptest.NewRecorder()
+	msg := wrapperspb.String("kapi")
+	if err := ProtoBufCodec.Render(messageBody{Code: 0, Data: msg}).Render(w); err != nil {
+		t.Fatal(err)
+	}
+	got := new(wrapperspb.StringValue)
+	if err := proto.Unmarshal(w.Body.Bytes(), got); err != nil || got.GetValue() != "kapi" {
+		t.Errorf("rendered %q, %v", got.GetValue(), err)
+	}
+}
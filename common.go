@@ -1,16 +1,17 @@
 package kapi
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
-	binding2 "github.com/gin-gonic/gin/binding"
 	"github.com/go-openapi/spec"
+	"github.com/go-playground/validator/v10"
 	binding3 "github.com/linxlib/binding"
 	"github.com/linxlib/kapi/internal"
 	"github.com/linxlib/kapi/internal/ast_parser"
 	"github.com/linxlib/kapi/internal/comment_parser"
-	"io"
+	"github.com/linxlib/kapi/internal/websocket"
+	"math"
 	"reflect"
 	"strings"
 )
@@ -23,36 +24,98 @@ func (invoke ContextInvoker) Invoke(params []interface{}) ([]reflect.Value, erro
 	return nil, nil
 }
 
+// routeOptions behaviours of a route declared by annotations
+type routeOptions struct {
+	route string       // method and path, the bucket of limit
+	limit *rateLimit   // @RATE, may be nil
+	cache *cachePolicy // @CACHE, may be nil
+}
+
+// newRouteOptions parse the annotations of item
+func newRouteOptions(item RouteItem, relativePath string) (routeOptions, error) {
+	opts := routeOptions{route: item.Method + " " + relativePath}
+	var err error
+	if opts.limit, err = parseRate(item.Rate); err != nil {
+		return opts, err
+	}
+	if opts.cache, err = parseCache(item.Cache); err != nil {
+		return opts, err
+	}
+	if opts.cache != nil && item.Method != "GET" {
+		return opts, fmt.Errorf("@CACHE is only for @GET, not @%s", item.Method)
+	}
+	return opts, nil
+}
+
 // handle get gin.HandlerFunc of a controller method
-func (b *KApi) handle(controller, method interface{}) gin.HandlerFunc {
+//
+//	@param name Controller.Method
+//	@param opts @RATE and @CACHE of the route
+//	@param controller
+//	@param method
+//
+//	@return gin.HandlerFunc
+func (b *KApi) handle(name string, opts routeOptions, controller, method interface{}) gin.HandlerFunc {
 	typ := reflect.TypeOf(method)
 	//TODO:
-	hasReq := typ.NumIn() >= 2
+	hasReq := typ.NumIn() >= 2 && typ.In(1) != wsConnType
 	reqIsValue := true
+	isWS := false
+	for i := 0; i < typ.NumIn(); i++ {
+		if typ.In(i) == wsConnType {
+			isWS = true
+		}
+	}
 
 	switch vt := method.(type) {
 	case func(*Context):
 		method = ContextInvoker(vt)
 	}
+	if _, ok := controller.(HeaderAuth); ok && opts.cache != nil {
+		opts.cache.auth = true
+	}
 	return func(context *gin.Context) {
 		c := newContext(context, b)
-		c.Map(c) //inject Context
+		c.Map(c)            //inject Context
+		defer c.inj.close() // cleanup values of request providers
+		var cw *cacheWriter // set if the response is buffered for @CACHE
+		var cacheKey string
+		defer func() {
+			// after recovery, so a panic responds through the writer of the request
+			if cw != nil {
+				opts.cache.finish(c, cacheKey, cw)
+			}
+		}()
 		defer func() {
 			if err := recover(); err != nil {
-
-				b.option.recoverErrorFunc(err)
-				if i, ok := controller.(OnPanic); ok {
-					i.OnPanic(c, err)
+				if isExit(err) {
+					return
 				}
+				b.handlePanic(c, controller, err)
 			}
 		}()
 
+		SpanFromContext(c.Request.Context()).SetName(name)
+		if opts.limit != nil && !opts.limit.allow(c, opts.route) {
+			return
+		}
 		if i, ok := controller.(HeaderAuth); ok {
-			i.HeaderAuth(c)
+			c.trace("HeaderAuth", func() error {
+				i.HeaderAuth(c)
+				return nil
+			})
 		}
 		if c.IsAborted() {
 			return
 		}
+		if opts.cache != nil {
+			// after HeaderAuth, so cached responses are not served to callers it rejects
+			cacheKey = opts.cache.key(c)
+			if opts.cache.serve(c, cacheKey) {
+				return
+			}
+			cw = opts.cache.buffer(c)
+		}
 		if hasReq {
 			var req reflect.Value
 			reqType := typ.In(1)
@@ -62,10 +125,18 @@ func (b *KApi) handle(controller, method interface{}) gin.HandlerFunc {
 				req = reflect.New(reqType.Elem())
 			}
 			if i, ok := controller.(BeforeBind); ok {
-				i.BeforeBind(c)
+				c.trace("BeforeBind", func() error {
+					i.BeforeBind(c)
+					return nil
+				})
 			}
-			if err := b.doBindReq(c, req.Interface()); err != nil {
-				b.handleUnmarshalError(c, err)
+			var err error
+			c.trace("bind", func() error {
+				err = b.doBindReq(c, req.Interface())
+				return err
+			})
+			if err != nil {
+				b.handleUnmarshalError(c, controller, err)
 				return
 			}
 			if reqIsValue {
@@ -73,129 +144,132 @@ func (b *KApi) handle(controller, method interface{}) gin.HandlerFunc {
 			}
 			c.Map(req.Interface())
 			if i, ok := controller.(AfterBind); ok {
-				i.AfterBind(c)
+				c.trace("AfterBind", func() error {
+					i.AfterBind(c)
+					return nil
+				})
+			}
+		}
+		if isWS {
+			// upgrade after auth and binding, so they can still respond
+			ws, err := b.upgrade(c)
+			if errors.Is(err, websocket.ErrBadOrigin) {
+				c.Respond(c.OnNoPermission(err.Error()))
+				return
 			}
+			if err != nil {
+				c.Respond(c.OnFail(err.Error(), nil))
+				return
+			}
+			defer b.release(ws)
+			c.Map(ws)
 		}
 		if i, ok := controller.(BeforeCall); ok {
-			i.BeforeCall(c)
+			c.trace("BeforeCall", func() error {
+				i.BeforeCall(c)
+				return nil
+			})
+		}
+		var returnValues []reflect.Value
+		var err error
+		served := false
+		defer func() {
+			// files are closed by WriteFile, or here if not served
+			if !served && len(returnValues) > 0 {
+				closeResult(returnValues[0].Interface())
+			}
+		}()
+		c.trace("call", func() error {
+			returnValues, err = c.inj.Invoke(method)
+			if err == nil && len(returnValues) == 2 {
+				rerr, _ := returnValues[1].Interface().(error)
+				return rerr
+			}
+			return err
+		})
+		var pe *ProviderError
+		if errors.As(err, &pe) {
+			b.respondError(c, pe, nil)
+			return
 		}
-		returnValues, err := c.inj.Invoke(method)
 		if err != nil {
 			panic(fmt.Sprintf("unable to invoke the handler [%T]: %controller", method, err))
 		}
+		if isWS {
+			return
+		}
 		if c.IsAborted() {
 			return
 		}
 		if i, ok := controller.(AfterCall); ok {
-			i.AfterCall(c)
+			c.trace("AfterCall", func() error {
+				i.AfterCall(c)
+				return nil
+			})
 		}
 		if c.IsAborted() {
 			return
 		}
+		if len(returnValues) == 1 {
+			served = true
+			b.respondResult(c, returnValues[0].Interface())
+		}
 		if len(returnValues) == 2 {
 			resp := returnValues[0].Interface()
 			rerr := returnValues[1].Interface()
 
 			if rerr != nil {
-				c.PureJSON(c.OnErrorDetail(rerr.(error).Error(), resp))
-			} else {
-				c.PureJSON(c.OnData("", 0, resp))
+				b.respondError(c, rerr.(error), resp)
+				return
+			}
+			served = true
+			if !b.respondResult(c, resp) {
+				c.Respond(c.OnData("", 0, resp))
 			}
 		}
 	}
 }
 
-func (b *KApi) handleUnmarshalError(c *Context, err error) {
-	var fields []string
-	if v, ok := binding3.HandleValidationErrors(err); ok {
-		fields = v
-	} else if _, ok := err.(*json.UnmarshalTypeError); ok {
-		err := err.(*json.UnmarshalTypeError)
-		tmp := fmt.Sprintf("%v:%v(but[%v])", err.Field, err.Type.String(), err.Value)
-		fields = append(fields, tmp)
-	} else {
-		fields = append(fields, err.Error())
+// handleUnmarshalError respond the error of binding. *StatusError is responded as is, validation and unmarshal
+// type errors are responded through OnValidationFail with field paths, unless the controller implements
+// OnValidationError or OnUnmarshalError
+func (b *KApi) handleUnmarshalError(c *Context, controller interface{}, err error) {
+	var se *StatusError
+	if errors.As(err, &se) {
+		// e.g. 415 of an unsupported Content-Type
+		b.respondError(c, err, nil)
+		return
 	}
-	c.PureJSON(c.OnFail(strings.Join(fields, ";"), fields))
-	return
+	if _, ok := err.(validator.ValidationErrors); ok {
+		if i, ok := controller.(OnValidationError); ok {
+			i.OnValidationError(c, err)
+			return
+		}
+	} else if i, ok := controller.(OnUnmarshalError); ok {
+		i.OnUnmarshalError(c, err)
+		return
+	}
+	if errs, ok := b.validationErrors(c, err); ok {
+		c.Respond(c.OnValidationFail(errs.Error(), errs))
+		return
+	}
+	c.Respond(c.OnFail(err.Error(), []string{err.Error()}))
 }
 
-// doBindReq bind request to multi tag field
+// doBindReq bind request through the binder pipeline, then validate it once
 //
 //	@param c
 //	@param v request param
 //
 //	@return error
 func (b *KApi) doBindReq(c *Context, v interface{}) error {
-	if err := c.ShouldBindHeader(v); err != nil {
-		if err != io.EOF {
-			if _, ok := binding3.HandleValidationErrors(err); ok {
-
-			} else {
-				internal.Errorf("ShouldBindHeader:%s", err)
-				return err
-			}
-		}
-	}
-
-	if err := c.ShouldBindUri(v); err != nil {
-		if err != io.EOF {
-			if _, ok := binding3.HandleValidationErrors(err); ok {
-
-			} else {
-				internal.Errorf("ShouldBindUri: %s", err)
-				return err
-			}
-
-		}
-	}
-	if err := binding3.Path.Bind(c.Context, v); err != nil {
-		if err != io.EOF {
-			if _, ok := binding3.HandleValidationErrors(err); ok {
-
-			} else {
-				internal.Errorf("Path.Bind:%s", err)
-				return err
-			}
-
-		}
-	}
-
-	if err := c.ShouldBindWith(v, binding3.Query); err != nil {
-		if err != io.EOF {
-			if _, ok := binding3.HandleValidationErrors(err); ok {
-
-			} else {
-				internal.Errorf("ShouldBindWith.Query:%s", err)
-				return err
-			}
-
-		}
-
-	}
-	if c.ContentType() == "multipart/form-data" {
-		if err := c.ShouldBindWith(v, binding2.FormMultipart); err != nil {
-			if err != io.EOF {
-				if _, ok := binding3.HandleValidationErrors(err); ok {
-
-				} else {
-					internal.Errorf("ShouldBindWith.FormMultipart:%s", err)
-					return err
-				}
-
-			}
-
-		}
-	}
-
-	if err := c.ShouldBindJSON(v); err != nil {
-		if err != io.EOF {
-			internal.Errorf("body EOF:%s", err)
+	for _, binder := range b.binders {
+		if err := binder.Bind(c, v); err != nil {
+			internal.Errorf("%s binder: %s", binder.Name(), err)
 			return err
 		}
-
 	}
-	return nil
+	return binding3.Validator.ValidateStruct(v)
 }
 
 func (b *KApi) getStruct(parser *ast_parser.Parser, methodComment *comment_parser.Comment, method *ast_parser.Method, req bool) (s *ast_parser.Struct) {
@@ -240,6 +314,15 @@ func (b *KApi) getStruct(parser *ast_parser.Parser, methodComment *comment_parse
 
 }
 
+// isFileResult check if the method returns a file which is served by WriteFile
+func isFileResult(method *ast_parser.Method) bool {
+	if len(method.Results) == 0 {
+		return false
+	}
+	t := method.Results[0].Type
+	return t == "kapi.FileResponse" || t == "io.ReadSeeker"
+}
+
 func (b *KApi) analysisController(controller interface{}, modPkg string, modFile string) bool {
 	controllerRefVal := reflect.ValueOf(controller)
 	internal.Debugf("%6s %s", ">", controllerRefVal.Type().String())
@@ -265,9 +348,23 @@ func (b *KApi) analysisController(controller interface{}, modPkg string, modFile
 		p := comment_parser.NewParser(method.Name, method.Docs)
 		methodComment := p.Parse(b.option.Server.BasePath + cp.Route) //base route
 
+		limit, err := parseRate(methodComment.Rate)
+		if err != nil {
+			internal.Errorf("%s.%s: %s", controllerType.Name(), method.Name, err)
+			return false
+		}
+		cache, err := parseCache(methodComment.Cache)
+		if err != nil {
+			internal.Errorf("%s.%s: %s", controllerType.Name(), method.Name, err)
+			return false
+		}
 		for m, r := range methodComment.Routes {
+			if cache != nil && r != "GET" {
+				internal.Errorf("%s.%s: @CACHE is only for @GET, not @%s", controllerType.Name(), method.Name, r)
+				return false
+			}
 			//add routes. which will be registered later
-			b.routeInfo.AddFunc(controllerType.Name()+"/"+method.Name, m, r)
+			b.routeInfo.AddFunc(controllerType.Name()+"/"+method.Name, m, r, methodComment)
 
 			if b.option.Server.NeedDoc {
 				if cp.Deprecated {
@@ -283,6 +380,9 @@ func (b *KApi) analysisController(controller interface{}, modPkg string, modFile
 				requestParams := b.doc.RequestParams(sReq)
 				sResp := b.getStruct(parser, methodComment, method, false)
 				responseParams := b.doc.ResponseParams(sResp)
+				if isFileResult(method) {
+					responseParams = b.doc.FileResponses()
+				}
 
 				// 方法可能注册为多条路由
 				for r, m := range methodComment.Routes {
@@ -297,6 +397,12 @@ func (b *KApi) analysisController(controller interface{}, modPkg string, modFile
 						tag,
 						requestParams,
 						responseParams)
+					if limit != nil {
+						b.doc.RateLimit(m, r, limit.limit, int(math.Ceil(limit.window.Seconds())), limit.by())
+					}
+					if cache != nil {
+						b.doc.Cache(m, r, int(cache.ttl.Seconds()), cache.vary)
+					}
 				}
 			}
 		}
@@ -313,6 +419,7 @@ func (b *KApi) analysisControllers(controllers ...interface{}) bool {
 	if !isFind {
 		return false
 	}
+	b.doc.SetMediaTypes(b.mediaTypes(), b.mediaTypes())
 	for _, c := range controllers {
 		if !b.analysisController(c, modPkg, modFile) {
 			return false
@@ -326,14 +433,21 @@ func (b *KApi) register(cList ...interface{}) bool {
 	defer internal.Spend("register routes")()
 	internal.Debugf("register routes..")
 	mp := b.routeInfo.GetGenInfo().Routes
+	for _, v := range b.mapped {
+		if err := b.applyConfig(v); err != nil {
+			internal.Errorf("%s", err)
+			return false
+		}
+	}
+	b.mapped = nil
 	for _, c := range cList {
 		refTyp := reflect.TypeOf(c)
 		refVal := reflect.ValueOf(c)
 		t := reflect.Indirect(refVal).Type()
 		objName := t.Name()
-		err := b.Apply(c)
-		if err != nil {
-			internal.Errorf("%+v", err)
+		// a controller can not work without its config, a *ConfigError fails the registration as well
+		if err := b.Apply(c); err != nil {
+			internal.Errorf("%s", err)
 			return false
 		}
 		// Install the Method
@@ -346,8 +460,8 @@ func (b *KApi) register(cList ...interface{}) bool {
 			for _, item := range mp {
 				if item.Key == k {
 					internal.Debugf("%6s  %-30s --> %s", item.Method, b.option.Server.BasePath+item.RouterPath, t.PkgPath()+".(*"+objName+")."+method.Name)
-					err := b.registerMethodToRouter(item.Method,
-						item.RouterPath,
+					err := b.registerMethodToRouter(item,
+						objName+"."+method.Name,
 						refVal.Interface(),
 						refVal.Method(m).Interface())
 					if err != nil {
@@ -364,20 +478,28 @@ func (b *KApi) register(cList ...interface{}) bool {
 
 // registerMethodToRouter register to gin router
 //
-//	@param router
-//	@param httpMethod
-//	@param relativePath route path
+//	@param item the route, with the http method, @RATE and @CACHE
+//	@param name Controller.Method, the name of the server span
 //	@param controller
 //	@param method
 //
 //	@return error
-func (b *KApi) registerMethodToRouter(httpMethod string, relativePath string, controller, method interface{}) error {
-	relativePath = b.option.Server.BasePath + relativePath
-	call := b.handle(controller, method)
+func (b *KApi) registerMethodToRouter(item RouteItem, name string, controller, method interface{}) error {
+	httpMethod := item.Method
+	routerPath := item.RouterPath
+	relativePath := b.option.Server.BasePath + routerPath
+	opts, err := newRouteOptions(item, relativePath)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if err := checkUploadTags(method); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	call := b.handle(name, opts, controller, method)
 	switch strings.ToUpper(httpMethod) {
 	case "POST":
 		b.engine.POST(relativePath, call)
-	case "GET":
+	case "GET", "SSE", "WS":
 		b.engine.GET(relativePath, call)
 	case "DELETE":
 		b.engine.DELETE(relativePath, call)
@@ -394,6 +516,10 @@ func (b *KApi) registerMethodToRouter(httpMethod string, relativePath string, co
 	default:
 		return fmt.Errorf("http method:[%v --> %s] not supported", httpMethod, relativePath)
 	}
+	if b.routePaths == nil {
+		b.routePaths = make(map[string]string)
+	}
+	b.routePaths[relativePath] = routerPath
 
 	return nil
 }
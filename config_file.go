@@ -0,0 +1,221 @@
+package kapi
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/linxlib/config"
+	"github.com/linxlib/kapi/internal"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// DefaultConfigFile the config file used if neither --config flag nor WithConfigFile is given
+const DefaultConfigFile = "config/config.yaml"
+
+// envPrefix prefix of env vars overriding config keys, e.g. KAPI_SERVER_PORT overrides server.port
+const envPrefix = "KAPI_"
+
+// WithConfigFile set the config file, the --config flag still takes precedence.
+// the config is read once after all options are applied
+//
+//	@param path
+//
+//	@return func(*Option)
+func WithConfigFile(path string) func(*Option) {
+	return func(o *Option) {
+		o.configFile = path
+		o.configSource = nil
+	}
+}
+
+// WithConfigSource read the config from yaml instead of a file, e.g. in tests. KAPI_ env vars still apply
+//
+//	@param yaml
+//
+//	@return func(*Option)
+func WithConfigSource(yaml []byte) func(*Option) {
+	return func(o *Option) {
+		o.configSource = yaml
+	}
+}
+
+// configFileFromArgs returns the value of --config or -config flag
+func configFileFromArgs(args []string) string {
+	for i, arg := range args {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "config" {
+			continue
+		}
+		if hasValue {
+			return value
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// configFile the config file in use: --config flag > WithConfigFile > DefaultConfigFile
+func (o *Option) configFilePath() string {
+	if f := configFileFromArgs(os.Args[1:]); f != "" {
+		return f
+	}
+	if o.configFile != "" {
+		return o.configFile
+	}
+	return DefaultConfigFile
+}
+
+// profileFile config.dev.yaml of config.yaml
+func profileFile(file string, profile string) string {
+	ext := filepath.Ext(file)
+	return strings.TrimSuffix(file, ext) + "." + profile + ext
+}
+
+// loadConfig merge the config file, the overlay of KAPI_PROFILE and KAPI_ env vars. the source set by
+// WithConfigSource replaces the files. an empty config is returned if there is no file at all,
+// so the app can run with defaults and env vars
+//
+//	@return *config.YAML
+//	@return []string files loaded
+//	@return error
+func (o *Option) loadConfig() (*config.YAML, []string, error) {
+	var files []string
+	if o.configSource != nil {
+		files = append(files, "(source)")
+	} else {
+		file := o.configFilePath()
+		candidates := []string{file}
+		if o.profile != "" {
+			candidates = append(candidates, profileFile(file, o.profile))
+		}
+		for i, f := range candidates {
+			if internal.FileIsExist(f) {
+				files = append(files, f)
+			} else if i > 0 {
+				internal.Warnf("config: %s of %sPROFILE=%s is missing", f, envPrefix, o.profile)
+			}
+		}
+	}
+	// options are made for each NewYAML, the reader of the source is consumed
+	sources := func() []config.YAMLOption {
+		if o.configSource != nil {
+			return []config.YAMLOption{config.Source(bytes.NewReader(o.configSource))}
+		}
+		opts := make([]config.YAMLOption, 0, len(files))
+		for _, f := range files {
+			opts = append(opts, config.File(f))
+		}
+		return opts
+	}
+	keys := make(map[string]bool)
+	if len(files) > 0 {
+		base, err := config.NewYAML(sources()...)
+		if err != nil {
+			return nil, files, err
+		}
+		configKeys("", base.Get(config.Root).Value(), keys)
+	}
+	structKeys("server", reflect.TypeOf(ServerOption{}), keys)
+	opts := sources()
+	if overrides := envOverrides(os.Environ(), keys); len(overrides) > 0 {
+		opts = append(opts, config.Static(overrides))
+	}
+	if len(opts) == 0 {
+		opts = append(opts, config.Static(map[string]any{}))
+	}
+	y, err := config.NewYAML(opts...)
+	return y, files, err
+}
+
+// configKeys add dot separated paths of all keys in a config tree to keys, true if the value is not a string
+func configKeys(prefix string, v any, keys map[string]bool) {
+	add := func(k string, child any) {
+		if prefix != "" {
+			k = prefix + "." + k
+		}
+		_, isString := child.(string)
+		keys[k] = !isString
+		configKeys(k, child, keys)
+	}
+	switch m := v.(type) {
+	case map[string]any:
+		for k, child := range m {
+			add(k, child)
+		}
+	case map[any]any:
+		for k, child := range m {
+			add(fmt.Sprint(k), child)
+		}
+	}
+}
+
+// structKeys add paths of the yaml tags of a struct to keys, true if the field is not a string.
+// so env vars can override keys not in the file
+func structKeys(prefix string, t reflect.Type, keys map[string]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name, _, _ := strings.Cut(sf.Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		k := prefix + "." + name
+		keys[k] = sf.Type.Kind() != reflect.String
+		if sf.Type.Kind() == reflect.Struct {
+			structKeys(k, sf.Type, keys)
+		}
+	}
+}
+
+// envOverrides build a config tree from KAPI_ env vars. KAPI_SERVER_PORT matches key server.port case insensitively,
+// names without a matching key are lower cased, e.g. KAPI_REDIS_ADDR is redis.addr.
+// values of keys which are not strings are parsed as yaml, so KAPI_SERVER_DEBUG=true is a bool.
+// others are kept as is, so KAPI_SERVER_ADMIN_TOKEN=007 stays "007"
+//
+//	@param environ
+//	@param keys known keys, true if the value is parsed as yaml
+//
+//	@return map[string]any
+func envOverrides(environ []string, keys map[string]bool) map[string]any {
+	known := make(map[string]string, len(keys))
+	for k := range keys {
+		known[strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(k))] = k
+	}
+	tree := make(map[string]any)
+	for _, kv := range environ {
+		name, value, _ := strings.Cut(kv, "=")
+		name, ok := strings.CutPrefix(name, envPrefix)
+		if !ok || name == "" || name == "PROFILE" {
+			continue
+		}
+		key, ok := known[name]
+		if !ok {
+			key = strings.ToLower(strings.ReplaceAll(name, "_", "."))
+		}
+		var v any = value
+		if keys[key] {
+			var parsed any
+			if err := yaml.Unmarshal([]byte(value), &parsed); err == nil && parsed != nil {
+				v = parsed
+			}
+		}
+		setPath(tree, strings.Split(key, "."), v)
+	}
+	return tree
+}
+
+func setPath(tree map[string]any, path []string, v any) {
+	for _, p := range path[:len(path)-1] {
+		child, ok := tree[p].(map[string]any)
+		if !ok {
+			child = make(map[string]any)
+			tree[p] = child
+		}
+		tree = child
+	}
+	tree[path[len(path)-1]] = v
+}
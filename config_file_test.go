@@ -0,0 +1,52 @@
+package kapi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEnvOverrides(t *testing.T) {
+	keys := map[string]bool{"app.name": false, "app.retries": true}
+	structKeys("server", reflect.TypeOf(ServerOption{}), keys)
+	tests := []struct {
+		name    string
+		environ []string
+		want    map[string]any
+	}{
+		{"string key kept as is", []string{"KAPI_SERVER_ADMIN_TOKEN=007"},
+			map[string]any{"server": map[string]any{"admin": map[string]any{"token": "007"}}}},
+		{"yaml in a string key", []string{"KAPI_APP_NAME=a: b"}, map[string]any{"app": map[string]any{"name": "a: b"}}},
+		{"int key", []string{"KAPI_SERVER_PORT=0x1F"}, map[string]any{"server": map[string]any{"port": 31}}},
+		{"bool key", []string{"KAPI_SERVER_DEBUG=true"}, map[string]any{"server": map[string]any{"debug": true}}},
+		{"key of the file", []string{"KAPI_APP_RETRIES=3"}, map[string]any{"app": map[string]any{"retries": 3}}},
+		{"case of the key", []string{"KAPI_SERVER_REQUESTIDINBODY=true"}, map[string]any{"server": map[string]any{"requestIdInBody": true}}},
+		{"unknown key", []string{"KAPI_REDIS_DB=1e3"}, map[string]any{"redis": map[string]any{"db": "1e3"}}},
+		{"invalid yaml", []string{"KAPI_SERVER_PORT=[1"}, map[string]any{"server": map[string]any{"port": "[1"}}},
+		{"skipped", []string{"KAPI_PROFILE=prod", "KAPI_=1", "HOME=/root", "kapi_server_port=1"}, map[string]any{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := envOverrides(tt.environ, keys); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("envOverrides(%v) = %#v, want %#v", tt.environ, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProfileFile(t *testing.T) {
+	tests := []struct {
+		file    string
+		profile string
+		want    string
+	}{
+		{"config/config.yaml", "prod", "config/config.prod.yaml"},
+		{"config.yml", "dev", "config.dev.yml"},
+		{"conf/app", "prod", "conf/app.prod"},
+		{"conf.d/app.yaml", "prod", "conf.d/app.prod.yaml"},
+	}
+	for _, tt := range tests {
+		if got := profileFile(tt.file, tt.profile); got != tt.want {
+			t.Errorf("profileFile(%q, %q) = %q, want %q", tt.file, tt.profile, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package kapi
+
+import (
+	"fmt"
+	binding3 "github.com/linxlib/binding"
+	"github.com/linxlib/inject"
+	"gopkg.in/yaml.v3"
+	"reflect"
+	"strings"
+)
+
+// ConfigError a section of an `inject:"config:<path>"` field is missing or invalid
+type ConfigError struct {
+	Path  string // path of the section, e.g. redis
+	Field string // Type.Field asking for it
+	Err   error
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("config %q of %s: %v", e.Path, e.Field, e.Err)
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// parseConfigTag parse `inject:"config:redis"` or `inject:"config:redis,optional"`
+//
+//	@return path
+//	@return optional the section may be missing, the defaults are used then
+//	@return ok false if it's not a config field
+func parseConfigTag(tag string) (path string, optional bool, ok bool) {
+	rest, ok := strings.CutPrefix(tag, "config:")
+	if !ok {
+		return "", false, false
+	}
+	path, opts, _ := strings.Cut(rest, ",")
+	return path, opts == "optional", true
+}
+
+// configValue populate a value of type t from the section of path in config.yaml.
+// fields with `default` tag are set before populating, and the value is validated by `binding` tags
+//
+//	@param path dot separated, e.g. redis or server.cors
+//	@param t struct, pointer of struct or a scalar type
+//	@param optional
+//
+//	@return reflect.Value
+//	@return error
+func (b *KApi) configValue(path string, t reflect.Type, optional bool) (reflect.Value, error) {
+	et := t
+	if t.Kind() == reflect.Ptr {
+		et = t.Elem()
+	}
+	v := reflect.New(et)
+	if err := setDefaults(v); err != nil {
+		return reflect.Value{}, err
+	}
+	if y := b.option.Get(); y != nil && y.Get(path).HasValue() {
+		if err := y.Get(path).Populate(v.Interface()); err != nil {
+			return reflect.Value{}, err
+		}
+	} else if !optional {
+		return reflect.Value{}, fmt.Errorf("key %q is missing in config file", path)
+	}
+	if et.Kind() == reflect.Struct {
+		if err := binding3.Validator.ValidateStruct(v.Interface()); err != nil {
+			return reflect.Value{}, err
+		}
+	}
+	if t.Kind() == reflect.Ptr {
+		return v, nil
+	}
+	return v.Elem(), nil
+}
+
+// applyConfig populate `inject:"config:<path>"` fields of a struct, other fields are left alone
+//
+//	@param val pointer of struct
+//
+//	@return error *ConfigError
+func (b *KApi) applyConfig(val any) error {
+	return applyWith(val, nil, b)
+}
+
+// Map map values. `inject:"config:<path>"` fields of mapped structs are populated when controllers are registered
+//
+//	@param values
+//
+//	@return inject.TypeMapper
+func (b *KApi) Map(values ...any) inject.TypeMapper {
+	b.mapped = append(b.mapped, values...)
+	b.Injector.Map(values...)
+	return b
+}
+
+// MapTo map val as the interface ifacePtr points to, config fields are populated like Map
+//
+//	@param val
+//	@param ifacePtr
+//
+//	@return inject.TypeMapper
+func (b *KApi) MapTo(val any, ifacePtr any) inject.TypeMapper {
+	b.mapped = append(b.mapped, val)
+	b.Injector.MapTo(val, ifacePtr)
+	return b
+}
+
+// setDefaults set zero fields which have `default` tag, the tag is parsed as yaml. nested structs included
+func setDefaults(ptr reflect.Value) error {
+	v := ptr.Elem()
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		field := v.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		if def, ok := sf.Tag.Lookup("default"); ok && field.IsZero() {
+			if err := yaml.Unmarshal([]byte(def), field.Addr().Interface()); err != nil {
+				return fmt.Errorf("default of %s: %w", sf.Name, err)
+			}
+			continue
+		}
+		if field.Kind() == reflect.Struct {
+			if err := setDefaults(field.Addr()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package kapi
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type testRedisConfig struct {
+	Addr    string `yaml:"addr" binding:"required"`
+	Timeout int    `yaml:"timeout" default:"5"`
+}
+
+type testConfigService struct {
+	Redis *testRedisConfig `inject:"config:redis"`
+}
+
+type testProvidedService testConfigService
+
+type testConfigController struct {
+	Missing testRedisConfig `inject:"config:missing"`
+}
+
+// newConfigKApi create a KApi reading the yaml as config file
+func newConfigKApi(t *testing.T, yaml string) *KApi {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(file, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return newTestKApi(t, WithConfigFile(file))
+}
+
+func TestConfigInject(t *testing.T) {
+	k := newConfigKApi(t, "redis:\n  addr: 127.0.0.1:6379\n")
+	svc := &testConfigService{}
+	k.Map(svc)
+	iface := &testConfigService{}
+	k.MapTo(iface, (*any)(nil))
+	if !k.register() {
+		t.Fatal("register() = false")
+	}
+	for _, s := range []*testConfigService{svc, iface} {
+		if s.Redis == nil || s.Redis.Addr != "127.0.0.1:6379" || s.Redis.Timeout != 5 {
+			t.Errorf("mapped service config = %+v", s.Redis)
+		}
+	}
+	_ = k.ProvideSingleton(func() *testProvidedService { return &testProvidedService{} })
+	v, ok := k.Value(reflect.TypeOf((*testProvidedService)(nil))).Interface().(*testProvidedService)
+	if !ok || v.Redis == nil || v.Redis.Addr != "127.0.0.1:6379" {
+		t.Errorf("provided service config = %+v", v)
+	}
+}
+
+func TestConfigInjectMissing(t *testing.T) {
+	k := newConfigKApi(t, "redis:\n  timeout: 1\n")
+	if k.register(&testConfigController{}) {
+		t.Error("register() of a controller without its config = true")
+	}
+	k = newConfigKApi(t, "redis:\n  timeout: 1\n")
+	k.Map(&testConfigService{})
+	if k.register() {
+		t.Error("register() of a service with invalid config = true")
+	}
+	_ = k.ProvideSingleton(func() *testProvidedService { return &testProvidedService{} })
+	var pe *ProviderError
+	if _, err := k.resolve(reflect.TypeOf((*testProvidedService)(nil))); !errors.As(err, &pe) {
+		t.Errorf("resolve() err = %v, want *ProviderError", err)
+	}
+}
@@ -0,0 +1,173 @@
+package kapi
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/linxlib/config"
+	"github.com/linxlib/kapi/internal"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// configPollInterval how often the config files are checked for changes
+const configPollInterval = 2 * time.Second
+
+// configSubscriber a func registered by OnConfigChange
+type configSubscriber struct {
+	path string
+	fn   func(config.Value)
+}
+
+// OnConfigChange call fn when the section of path changes after the config is reloaded, e.g.
+//
+//	k.OnConfigChange("redis", func(v config.Value) {
+//		var opt RedisOption
+//		if err := v.Populate(&opt); err == nil {
+//			client.Reset(opt)
+//		}
+//	})
+//
+// fn runs in the goroutine reloading the config, after the reload finished, so it may call ReloadConfig or
+// OnConfigChange itself. v has no value if the section is removed. an empty path subscribes to the whole config
+//
+//	@param path dot separated, e.g. server.cors
+//	@param fn
+func (b *KApi) OnConfigChange(path string, fn func(v config.Value)) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	b.configSubscribers = append(b.configSubscribers, configSubscriber{path: path, fn: fn})
+}
+
+// ReloadConfig read the config files and env vars again. the new config is validated before it replaces
+// the current one, subscribers of changed sections are notified then. CORS and log level apply at once,
+// other server options need a restart. a file loaded before must still exist, so an editor saving it by
+// remove and rename doesn't reset the config to defaults in between
+//
+//	@return error the current config is kept
+func (b *KApi) ReloadConfig() error {
+	b.configLock.Lock()
+	notify, err := b.reloadConfig()
+	b.configLock.Unlock()
+	for _, f := range notify {
+		f()
+	}
+	return err
+}
+
+// reloadConfig replace the config, configLock is held
+//
+//	@return []func() calls of the subscribers of changed sections
+//	@return error
+func (b *KApi) reloadConfig() ([]func(), error) {
+	conf, files, err := b.option.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range b.option.configFiles {
+		if !slices.Contains(files, f) {
+			return nil, fmt.Errorf("config file %s is missing", f)
+		}
+	}
+	if _, err := serverOption(conf); err != nil {
+		return nil, err
+	}
+	old := b.option.y.Swap(conf)
+	b.option.configFiles = files
+	internal.Infof("config reloaded %s", strings.Join(files, ", "))
+	var notify []func()
+	for _, s := range b.configSubscribers {
+		v := conf.Get(s.path)
+		if old != nil && reflect.DeepEqual(old.Get(s.path).Value(), v.Value()) {
+			continue
+		}
+		fn := s.fn
+		notify = append(notify, func() { fn(v) })
+	}
+	return notify, nil
+}
+
+// watchConfig reload the config when a config file changes or on SIGHUP, until the server is shut down
+func (b *KApi) watchConfig() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+	last := b.configModTimes()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-hup:
+		case <-ticker.C:
+			mt := b.configModTimes()
+			if reflect.DeepEqual(mt, last) {
+				continue
+			}
+			last = mt
+		}
+		if err := b.ReloadConfig(); err != nil {
+			internal.Errorf("reload config: %s", err)
+		}
+	}
+}
+
+// configModTimes modification times of the config file and the profile overlay, zero if missing
+func (b *KApi) configModTimes() []time.Time {
+	if b.option.configSource != nil {
+		return nil
+	}
+	file := b.option.configFilePath()
+	files := []string{file}
+	if b.option.profile != "" {
+		files = append(files, profileFile(file, b.option.profile))
+	}
+	mt := make([]time.Time, len(files))
+	for i, f := range files {
+		if fi, err := os.Stat(f); err == nil {
+			mt[i] = fi.ModTime()
+		}
+	}
+	return mt
+}
+
+// liveConfig subscribe the built-in handlers which follow the config
+func (b *KApi) liveConfig() {
+	b.OnConfigChange("server.cors", func(v config.Value) {
+		c := _defaultServerOption.Cors
+		if v.HasValue() {
+			if err := v.Populate(&c); err != nil {
+				internal.Errorf("server.cors: %s", err)
+				return
+			}
+		}
+		server := _defaultServerOption
+		server.Cors = c
+		b.option.applyOverrides(&server)
+		b.option.setCors(server.Cors)
+		internal.Infof("cors updated")
+	})
+	b.OnConfigChange("server.logLevel", func(v config.Value) {
+		var s string
+		if v.HasValue() {
+			_ = v.Populate(&s)
+		}
+		server := _defaultServerOption
+		server.LogLevel = s
+		b.option.applyOverrides(&server)
+		level, _ := internal.ParseLevel(server.LogLevel)
+		internal.SetLevel(level)
+	})
+}
+
+// corsHandler the current CORS handler
+func (b *KApi) corsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		b.option.corsHandler.Load().(gin.HandlerFunc)(c)
+	}
+}
@@ -0,0 +1,106 @@
+package kapi
+
+import (
+	"github.com/linxlib/config"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReloadConfig(t *testing.T) {
+	const initial = "redis:\n  addr: a\napp:\n  name: x\n"
+	tests := []struct {
+		name    string
+		change  func(file string) error
+		wantErr bool
+		addr    string // redis.addr after the reload
+		notify  []string
+	}{
+		{
+			name: "changed section",
+			change: func(file string) error {
+				return os.WriteFile(file, []byte("redis:\n  addr: b\napp:\n  name: x\n"), 0o644)
+			},
+			addr:   "b",
+			notify: []string{"redis", ""},
+		},
+		{
+			name:   "removed section",
+			change: func(file string) error { return os.WriteFile(file, []byte("redis:\n  addr: a\n"), 0o644) },
+			addr:   "a",
+			notify: []string{"app", ""},
+		},
+		{
+			name:    "missing file",
+			change:  os.Remove,
+			wantErr: true,
+			addr:    "a",
+		},
+		{
+			name:    "invalid server option",
+			change:  func(file string) error { return os.WriteFile(file, []byte("server:\n  logLevel: loud\n"), 0o644) },
+			wantErr: true,
+			addr:    "a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newConfigKApi(t, initial)
+			var notified []string
+			for _, path := range []string{"redis", "app", ""} {
+				path := path
+				k.OnConfigChange(path, func(v config.Value) {
+					notified = append(notified, path)
+				})
+			}
+			if err := tt.change(k.option.configFilePath()); err != nil {
+				t.Fatal(err)
+			}
+			err := k.ReloadConfig()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReloadConfig() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			var addr string
+			_ = k.option.Get().Get("redis.addr").Populate(&addr)
+			if addr != tt.addr {
+				t.Errorf("redis.addr = %q, want %q", addr, tt.addr)
+			}
+			if len(notified) != len(tt.notify) {
+				t.Fatalf("notified %v, want %v", notified, tt.notify)
+			}
+			for i := range notified {
+				if notified[i] != tt.notify[i] {
+					t.Errorf("notified %v, want %v", notified, tt.notify)
+				}
+			}
+		})
+	}
+}
+
+func TestReloadConfigFromSubscriber(t *testing.T) {
+	k := newConfigKApi(t, "redis:\n  addr: a\n")
+	file := k.option.configFilePath()
+	reloaded := make(chan error, 1)
+	k.OnConfigChange("redis", func(v config.Value) {
+		k.OnConfigChange("app", func(config.Value) {})
+		reloaded <- k.ReloadConfig()
+	})
+	if err := os.WriteFile(file, []byte("redis:\n  addr: b\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- k.ReloadConfig()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("ReloadConfig() deadlocked")
+	}
+	if err := <-reloaded; err != nil {
+		t.Errorf("ReloadConfig() of the subscriber: %v", err)
+	}
+}
@@ -1,47 +1,53 @@
 package kapi
 
 import (
+	"bytes"
 	"github.com/gin-gonic/gin"
 	"github.com/linxlib/conv"
 	"github.com/linxlib/inject"
+	"log/slog"
 	"strings"
 )
 
 // Context KApi Context
 type Context struct {
 	*gin.Context
-	inj            inject.Injector
-	OnSuccess      IOnSuccess      `inject:""`
-	OnFail         IOnFail         `inject:""`
-	OnNotFound     IOnNotFound     `inject:""`
-	OnNoPermission IOnNoPermission `inject:""`
-	OnError        IOnError        `inject:""`
-	OnErrorDetail  IOnErrorDetail  `inject:""`
-	OnUnAuthed     IOnUnAuthed     `inject:""`
-	OnData         IOnData         `inject:""`
+	inj              *requestScope
+	kapi             *KApi
+	logger           *slog.Logger
+	OnSuccess        IOnSuccess
+	OnFail           IOnFail
+	OnNotFound       IOnNotFound
+	OnNoPermission   IOnNoPermission
+	OnError          IOnError
+	OnErrorDetail    IOnErrorDetail
+	OnUnAuthed       IOnUnAuthed
+	OnData           IOnData
+	OnPage           IOnPage
+	OnValidationFail IOnValidationFail
+	OnStatus         IOnStatus
 }
 
 // newContext create a new custom context
-func newContext(c *gin.Context, parent ...inject.Injector) *Context {
+func newContext(c *gin.Context, k *KApi) *Context {
 	cc := &Context{
 		Context: c,
-		inj:     inject.New(),
-	}
-	if len(parent) > 0 {
-		cc.inj.SetParent(parent[0])
-	}
-	err := cc.inj.Apply(cc)
-	if err != nil {
-		var builder = NewDefaultBuilder()
-		cc.OnSuccess = builder.OnSuccess
-		cc.OnFail = builder.OnFail
-		cc.OnNotFound = builder.OnNotFound
-		cc.OnNoPermission = builder.OnNoPermission
-		cc.OnError = builder.OnError
-		cc.OnUnAuthed = builder.OnUnAuthed
-		cc.OnData = builder.OnData
-		cc.OnErrorDetail = builder.OnErrorDetail
+		inj:     newRequestScope(k),
+		kapi:    k,
 	}
+	// result builders with the same signature can not be told apart by inject, so copy them from KApi
+	var builder = k.resultBuilder
+	cc.OnSuccess = builder.OnSuccess
+	cc.OnFail = builder.OnFail
+	cc.OnNotFound = builder.OnNotFound
+	cc.OnNoPermission = builder.OnNoPermission
+	cc.OnError = builder.OnError
+	cc.OnUnAuthed = builder.OnUnAuthed
+	cc.OnData = builder.OnData
+	cc.OnPage = builder.OnPage
+	cc.OnErrorDetail = builder.OnErrorDetail
+	cc.OnValidationFail = builder.OnValidationFail
+	cc.OnStatus = builder.OnStatus
 	return cc
 }
 
@@ -365,11 +371,11 @@ func (c *Context) Provide(i interface{}) error {
 	return c.inj.Provide(i)
 }
 
-// File returns a file
+// File returns a file as attachment, the content type is detected by the extension of fileName.
+// use WriteFile or return a FileResponse for large files
 //
 //	@param fileName
 //	@param fileData
 func (c *Context) File(fileName string, fileData []byte) {
-	c.Header("Content-Disposition", "attachment; filename=\""+fileName+"\"")
-	c.Data(200, "application/octet-stream", fileData)
+	c.WriteFile(&FileResponse{Name: fileName, Content: bytes.NewReader(fileData)})
 }
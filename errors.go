@@ -0,0 +1,135 @@
+package kapi
+
+import (
+	"errors"
+	"github.com/linxlib/kapi/internal"
+	"net/http"
+	"reflect"
+)
+
+// sentinel errors which are mapped by default. handlers can return them directly or wrap them with fmt.Errorf("%w")
+var (
+	ErrBadRequest   = errors.New("bad request")
+	ErrUnAuthed     = errors.New("un authed")
+	ErrNoPermission = errors.New("no permission")
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+)
+
+// ErrorMapping the response of an error returned by handler
+type ErrorMapping struct {
+	Status int    // http status code, 500 if 0
+	Code   int    // business code
+	Msg    string // message of response, err.Error() will be used if empty
+}
+
+// StatusError an error carries its own response
+type StatusError struct {
+	Status int // http status code, 500 if 0
+	Code   int
+	Msg    string
+	Err    error
+}
+
+// NewStatusError create an error which responds with status and msg
+//
+//	@param status http status code
+//	@param msg
+//
+//	@return *StatusError
+func NewStatusError(status int, msg string) *StatusError {
+	return &StatusError{Status: status, Msg: msg}
+}
+
+func (e *StatusError) Error() string {
+	if e.Msg == "" && e.Err != nil {
+		return e.Err.Error()
+	}
+	return e.Msg
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+type errorMappingItem struct {
+	target  error        // matched by errors.Is
+	typ     reflect.Type // matched by errors.As
+	mapping ErrorMapping
+}
+
+func defaultErrorMappings() []errorMappingItem {
+	return []errorMappingItem{
+		{target: ErrBadRequest, mapping: ErrorMapping{Status: http.StatusBadRequest}},
+		{target: ErrUnAuthed, mapping: ErrorMapping{Status: http.StatusUnauthorized}},
+		{target: ErrNoPermission, mapping: ErrorMapping{Status: http.StatusForbidden}},
+		{target: ErrNotFound, mapping: ErrorMapping{Status: http.StatusNotFound}},
+		{target: ErrConflict, mapping: ErrorMapping{Status: http.StatusConflict}},
+	}
+}
+
+// MapError map a sentinel error to a response, matched by errors.Is. mappings added later take precedence
+//
+//	@param target sentinel error
+//	@param mapping
+func (b *KApi) MapError(target error, mapping ErrorMapping) {
+	if target == nil {
+		panic("MapError: target is nil")
+	}
+	b.errorMappings = append([]errorMappingItem{{target: target, mapping: mapping}}, b.errorMappings...)
+}
+
+// MapErrorType map an error type to a response, matched by errors.As. mappings added later take precedence
+//
+//	@param target nil value of the error type, e.g. (*ConflictError)(nil)
+//	@param mapping
+func (b *KApi) MapErrorType(target error, mapping ErrorMapping) {
+	if target == nil {
+		panic("MapErrorType: target is nil, want a nil value of the error type like (*ConflictError)(nil)")
+	}
+	b.errorMappings = append([]errorMappingItem{{typ: reflect.TypeOf(target), mapping: mapping}}, b.errorMappings...)
+}
+
+// findErrorMapping find the mapping of err, a status of 0 is 500
+func (b *KApi) findErrorMapping(err error) (ErrorMapping, bool) {
+	m, ok := b.matchErrorMapping(err)
+	if ok && m.Status == 0 {
+		m.Status = http.StatusInternalServerError
+	}
+	return m, ok
+}
+
+func (b *KApi) matchErrorMapping(err error) (ErrorMapping, bool) {
+	var se *StatusError
+	if errors.As(err, &se) {
+		return ErrorMapping{Status: se.Status, Code: se.Code, Msg: se.Error()}, true
+	}
+	for _, item := range b.errorMappings {
+		if item.target != nil && errors.Is(err, item.target) {
+			return item.mapping, true
+		}
+		if item.typ != nil && errors.As(err, reflect.New(item.typ).Interface()) {
+			return item.mapping, true
+		}
+	}
+	return ErrorMapping{}, false
+}
+
+// respondError respond the error returned by handler. unmapped errors respond 500,
+// the detail of them only shows in debug mode
+func (b *KApi) respondError(c *Context, err error, resp any) {
+	if m, ok := b.findErrorMapping(err); ok {
+		msg := m.Msg
+		if msg == "" {
+			msg = err.Error()
+		}
+		c.Respond(c.OnStatus(m.Status, m.Code, msg))
+		return
+	}
+	internal.Errorf("%s %s: %s", c.Request.Method, c.FullPath(), err)
+	if b.option.Server.Debug {
+		c.Respond(c.OnErrorDetail(err.Error(), resp))
+		return
+	}
+	c.Respond(c.OnErrorDetail(http.StatusText(http.StatusInternalServerError), nil))
+}
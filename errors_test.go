@@ -0,0 +1,94 @@
+package kapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type conflictError struct{ id string }
+
+func (e *conflictError) Error() string {
+	return "conflict of " + e.id
+}
+
+var (
+	errNoRows   = errors.New("no rows")
+	errNoStatus = errors.New("no status")
+)
+
+func TestRespondError(t *testing.T) {
+	tests := []struct {
+		name   string
+		debug  bool
+		err    error
+		status int
+		code   int
+		msg    string
+	}{
+		{"bad request", false, ErrBadRequest, 400, -1, "bad request"},
+		{"un authed", false, ErrUnAuthed, 401, -1, "un authed"},
+		{"no permission", false, ErrNoPermission, 403, -1, "no permission"},
+		{"conflict", false, ErrConflict, 409, -1, "conflict"},
+		{"wrapped sentinel", false, fmt.Errorf("user 1: %w", ErrNotFound), 404, 10404, "record not found"},
+		{"mapped later wins", false, errNoRows, 404, 10404, "record not found"},
+		{"mapped type", false, fmt.Errorf("save: %w", &conflictError{id: "1"}), 409, 7, "save: conflict of 1"},
+		{"status error", false, &StatusError{Status: 418, Code: 3, Msg: "teapot"}, 418, 3, "teapot"},
+		{"status error over mappings", false, &StatusError{Status: 422, Err: ErrNotFound}, 422, -1, "not found"},
+		{"status error without status", false, &StatusError{Msg: "oops"}, 500, -1, "oops"},
+		{"mapping without status", false, errNoStatus, 500, 9, "no status"},
+		{"unmapped", false, errors.New("secret detail"), 500, -1, "Internal Server Error"},
+		{"unmapped in debug", true, errors.New("secret detail"), 500, -1, "secret detail"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newTestKApi(t, WithServer(func(s *ServerOption) {
+				s.Debug = tt.debug
+			}))
+			k.MapError(errNoRows, ErrorMapping{Status: 500})
+			// added later, so it takes precedence over the mapping above and the default of ErrNotFound
+			k.MapError(errNoRows, ErrorMapping{Status: 404, Code: 10404, Msg: "record not found"})
+			k.MapError(ErrNotFound, ErrorMapping{Status: 404, Code: 10404, Msg: "record not found"})
+			k.MapErrorType((*conflictError)(nil), ErrorMapping{Status: 409, Code: 7})
+			k.MapError(errNoStatus, ErrorMapping{Code: 9})
+			k.route(http.MethodGet, "/x", nil, func(c *Context) (any, error) {
+				return nil, tt.err
+			})
+			w := serve(k, httptest.NewRequest(http.MethodGet, "/x", nil))
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			var body messageBody
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Code != tt.code || body.Msg != tt.msg {
+				t.Errorf("code, msg = %d, %q, want %d, %q", body.Code, body.Msg, tt.code, tt.msg)
+			}
+		})
+	}
+}
+
+func TestMapErrorNil(t *testing.T) {
+	tests := []struct {
+		name string
+		f    func(k *KApi)
+	}{
+		{"MapError", func(k *KApi) { k.MapError(nil, ErrorMapping{Status: 400}) }},
+		{"MapErrorType", func(k *KApi) { k.MapErrorType(nil, ErrorMapping{Status: 400}) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newTestKApi(t)
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s(nil) did not panic", tt.name)
+				}
+			}()
+			tt.f(k)
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package kapi
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileResponse a file returned by a controller method. it is served with Range, If-Range,
+// If-None-Match and If-Modified-Since support:
+//
+//	// @GET /export
+//	func (e *Example) Export(c *kapi.Context) (*kapi.FileResponse, error) {
+//		f, err := os.Open("export.xlsx")
+//		if err != nil {
+//			return nil, err
+//		}
+//		return &kapi.FileResponse{Name: "导出.xlsx", Content: f}, nil
+//	}
+//
+// returning an io.ReadSeeker is the same as a FileResponse without Name. Content is closed if it is an io.Closer,
+// after served or when it is not served: returned with an error, or the request is aborted by AfterCall.
+//
+// conditional requests and If-Range need an ETag or Last-Modified. set ModTime or ETag if Content is not
+// an *os.File and may be larger than 1MB, smaller content gets an ETag of its hash
+type FileResponse struct {
+	Content     io.ReadSeeker // responds 404 if nil
+	Name        string        // file name of Content-Disposition, non-ASCII names are encoded as RFC 6266 filename*
+	ContentType string        // detected by the extension of Name, then by sniffing the content if empty
+	ModTime     time.Time     // Last-Modified, taken from the file if Content is *os.File
+	ETag        string        // generated from ModTime and size if empty, or from the hash of content up to 1MB
+	Inline      bool          // show in browser instead of downloading
+}
+
+// maxHashedSize content up to it gets an ETag of its hash if there is no ModTime
+const maxHashedSize = 1 << 20
+
+// WriteFile serve a file, see FileResponse
+//
+//	@param f
+func (c *Context) WriteFile(f *FileResponse) {
+	if f == nil || f.Content == nil {
+		c.Respond(c.OnNotFound(http.StatusText(http.StatusNotFound)))
+		return
+	}
+	if closer, ok := f.Content.(io.Closer); ok {
+		defer closer.Close()
+	}
+	size := int64(-1)
+	if file, ok := f.Content.(*os.File); ok {
+		if fi, err := file.Stat(); err == nil {
+			size = fi.Size()
+			if f.ModTime.IsZero() {
+				f.ModTime = fi.ModTime()
+			}
+			if f.Name == "" {
+				f.Name = filepath.Base(fi.Name())
+			}
+		}
+	}
+	if size < 0 {
+		if end, err := f.Content.Seek(0, io.SeekEnd); err == nil {
+			size = end
+			_, _ = f.Content.Seek(0, io.SeekStart)
+		}
+	}
+	h := c.Writer.Header()
+	if f.ContentType != "" {
+		h.Set("Content-Type", f.ContentType)
+	}
+	if f.ETag != "" {
+		h.Set("ETag", f.ETag)
+	} else if !f.ModTime.IsZero() && size >= 0 {
+		// strong, so If-Range matches it
+		h.Set("ETag", fmt.Sprintf(`"%x-%x"`, f.ModTime.UnixNano(), size))
+	} else if size >= 0 && size <= maxHashedSize {
+		if etag, err := hashETag(f.Content, size); err == nil {
+			h.Set("ETag", etag)
+		}
+	}
+	if f.Name != "" || !f.Inline {
+		h.Set("Content-Disposition", contentDisposition(f.Inline, f.Name))
+	}
+	// ServeContent handles Range/If-Range and conditional requests, and detects Content-Type by the name
+	http.ServeContent(c.Writer, c.Request, f.Name, f.ModTime, f.Content)
+}
+
+// hashETag a strong ETag of size and the sha256 of content, content is rewound after
+func hashETag(content io.ReadSeeker, size int64) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`"%x-%x"`, size, h.Sum(nil)[:16]), nil
+}
+
+// contentDisposition RFC 6266 header value. non-ASCII names are sent as filename* with an ASCII fallback
+// for old browsers
+func contentDisposition(inline bool, name string) string {
+	typ := "attachment"
+	if inline {
+		typ = "inline"
+	}
+	if name == "" {
+		return typ
+	}
+	var fallback strings.Builder
+	ascii := true
+	for _, r := range name {
+		switch {
+		case r == '"' || r == '\\':
+			fallback.WriteByte('_')
+		case r < 0x20 || r == 0x7f:
+			ascii = false
+		case r > 0x7e:
+			ascii = false
+			fallback.WriteByte('_')
+		default:
+			fallback.WriteRune(r)
+		}
+	}
+	v := typ + `; filename="` + fallback.String() + `"`
+	if !ascii {
+		v += "; filename*=UTF-8''" + escapeAttrChars(name)
+	}
+	return v
+}
+
+// escapeAttrChars percent encode bytes which are not attr-char of RFC 5987
+func escapeAttrChars(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+// closeResult close the content of a file result which is not served
+func closeResult(v any) {
+	var content io.ReadSeeker
+	switch r := v.(type) {
+	case *FileResponse:
+		if r != nil {
+			content = r.Content
+		}
+	case FileResponse:
+		content = r.Content
+	case io.ReadSeeker:
+		content = r
+	}
+	if closer, ok := content.(io.Closer); ok {
+		_ = closer.Close()
+	}
+}
+
+// respondResult serve the results which are not responded by OnData: event streams, files and pages
+//
+//	@return bool false if v should be responded by OnData
+func (b *KApi) respondResult(c *Context, v any) bool {
+	switch r := v.(type) {
+	case <-chan Event:
+		b.serveEvents(c, r)
+	case chan Event:
+		b.serveEvents(c, r)
+	case *FileResponse:
+		if r == nil {
+			return false
+		}
+		c.WriteFile(r)
+	case FileResponse:
+		c.WriteFile(&r)
+	case io.ReadSeeker:
+		c.WriteFile(&FileResponse{Content: r})
+	case *Page:
+		if r == nil {
+			return false
+		}
+		c.respondPage(r)
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,145 @@
+package kapi
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// closeCounter content which counts Close calls
+type closeCounter struct {
+	*bytes.Reader
+	closed int
+}
+
+func (c *closeCounter) Close() error {
+	c.closed++
+	return nil
+}
+
+func TestWriteFile(t *testing.T) {
+	k := newTestKApi(t)
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	k.route(http.MethodGet, "/file", nil, func(c *Context) (*FileResponse, error) {
+		return &FileResponse{Name: "data.txt", Content: strings.NewReader("0123456789"), ModTime: modTime}, nil
+	})
+	w := serve(k, httptest.NewRequest(http.MethodGet, "/file", nil))
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || strings.HasPrefix(etag, "W/") || etag == "" {
+		t.Fatalf("status = %d, ETag = %q, want 200 with a strong ETag", w.Code, etag)
+	}
+	tests := []struct {
+		name   string
+		header map[string]string
+		status int
+		body   string
+	}{
+		{"range", map[string]string{"Range": "bytes=2-4"}, http.StatusPartialContent, "234"},
+		{"if-range matches", map[string]string{"Range": "bytes=2-4", "If-Range": etag}, http.StatusPartialContent, "234"},
+		{"if-range differs", map[string]string{"Range": "bytes=2-4", "If-Range": `"other"`}, http.StatusOK, "0123456789"},
+		{"if-none-match", map[string]string{"If-None-Match": etag}, http.StatusNotModified, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/file", nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			w := serve(k, req)
+			if w.Code != tt.status || w.Body.String() != tt.body {
+				t.Errorf("got %d %q, want %d %q", w.Code, w.Body, tt.status, tt.body)
+			}
+		})
+	}
+}
+
+type abortAfterCall struct{}
+
+func (abortAfterCall) AfterCall(c *Context) {
+	c.AbortWithStatus(http.StatusTeapot)
+}
+
+func TestFileResultClosed(t *testing.T) {
+	tests := []struct {
+		name       string
+		controller any
+		err        error
+		status     int
+	}{
+		{"served", nil, nil, http.StatusOK},
+		{"returned with an error", nil, errors.New("failed"), http.StatusInternalServerError},
+		{"aborted by AfterCall", abortAfterCall{}, nil, http.StatusTeapot},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newTestKApi(t)
+			content := &closeCounter{Reader: bytes.NewReader([]byte("data"))}
+			k.route(http.MethodGet, "/file", tt.controller, func(c *Context) (*FileResponse, error) {
+				return &FileResponse{Content: content}, tt.err
+			})
+			w := serve(k, httptest.NewRequest(http.MethodGet, "/file", nil))
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if content.closed != 1 {
+				t.Errorf("content closed %d times, want 1", content.closed)
+			}
+		})
+	}
+}
+
+func TestWriteFileWithoutModTime(t *testing.T) {
+	large := strings.Repeat("a", maxHashedSize+1)
+	tests := []struct {
+		name    string
+		file    *FileResponse
+		status  int
+		hasETag bool // and If-Range, If-None-Match work with it
+	}{
+		{"nil content", &FileResponse{Name: "a.txt"}, http.StatusNotFound, false},
+		{"small content", &FileResponse{Content: strings.NewReader("0123456789")}, http.StatusOK, true},
+		{"own ETag", &FileResponse{Content: strings.NewReader("0123456789"), ETag: `"v1"`}, http.StatusOK, true},
+		{"large content", &FileResponse{Content: strings.NewReader(large)}, http.StatusOK, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newTestKApi(t)
+			k.route(http.MethodGet, "/file", nil, func(c *Context) {
+				file := *tt.file
+				if s, ok := file.Content.(*strings.Reader); ok {
+					_, _ = s.Seek(0, 0)
+				}
+				c.WriteFile(&file)
+			})
+			w := serve(k, httptest.NewRequest(http.MethodGet, "/file", nil))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			etag := w.Header().Get("ETag")
+			if (etag != "") != tt.hasETag {
+				t.Fatalf("ETag = %q, want one: %v", etag, tt.hasETag)
+			}
+			if tt.status == http.StatusOK && w.Body.Len() != int(tt.file.Content.(*strings.Reader).Size()) {
+				t.Errorf("body of %d bytes, the ETag did not rewind the content", w.Body.Len())
+			}
+			if !tt.hasETag {
+				return
+			}
+			req := httptest.NewRequest(http.MethodGet, "/file", nil)
+			req.Header.Set("Range", "bytes=2-4")
+			req.Header.Set("If-Range", etag)
+			if w := serve(k, req); w.Code != http.StatusPartialContent || w.Body.String() != "234" {
+				t.Errorf("If-Range: %d %q", w.Code, w.Body)
+			}
+			req = httptest.NewRequest(http.MethodGet, "/file", nil)
+			req.Header.Set("If-None-Match", etag)
+			if w := serve(k, req); w.Code != http.StatusNotModified {
+				t.Errorf("If-None-Match: status = %d", w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package kapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HealthCheck returns nil if the dependency is healthy, ctx is done when the check times out
+type HealthCheck func(ctx context.Context) error
+
+// HealthCheckOption options of a health check
+type HealthCheckOption func(h *healthCheck)
+
+// CheckLiveness the check is in /livez too, a failed liveness check means the process should be restarted
+func CheckLiveness() HealthCheckOption {
+	return func(h *healthCheck) {
+		h.liveness = true
+	}
+}
+
+// CheckTimeout the check fails if it takes longer than d, default 2s
+func CheckTimeout(d time.Duration) HealthCheckOption {
+	return func(h *healthCheck) {
+		h.timeout = d
+	}
+}
+
+// CheckCacheTTL the result is reused for d, default 1s. 0 runs the check on every probe
+func CheckCacheTTL(d time.Duration) HealthCheckOption {
+	return func(h *healthCheck) {
+		h.ttl = d
+	}
+}
+
+// healthCheck a registered check and its last result
+type healthCheck struct {
+	name     string
+	check    HealthCheck
+	liveness bool
+	timeout  time.Duration
+	ttl      time.Duration
+
+	lock      sync.Mutex
+	result    healthResult
+	checkedAt time.Time
+}
+
+// healthResult a row of the detailed output
+type healthResult struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"` // ok or fail
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+	Cached   bool   `json:"cached,omitempty"`
+}
+
+// run the check, or return the cached result
+func (h *healthCheck) run(ctx context.Context) healthResult {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.ttl > 0 && !h.checkedAt.IsZero() && time.Since(h.checkedAt) < h.ttl {
+		r := h.result
+		r.Cached = true
+		return r
+	}
+	// a probe which gave up must not cache a canceled result
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), h.timeout)
+	defer cancel()
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				done <- fmt.Errorf("panic: %v", err)
+			}
+		}()
+		done <- h.check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("timeout after %s", h.timeout)
+		}
+	}
+	h.result = healthResult{Name: h.name, Status: "ok", Duration: time.Since(start).Round(time.Microsecond).String()}
+	if err != nil {
+		h.result.Status = "fail"
+		h.result.Error = err.Error()
+	}
+	h.checkedAt = time.Now()
+	return h.result
+}
+
+// AddHealthCheck register a readiness check, e.g.
+//
+//	k.AddHealthCheck("db", db.PingContext, kapi.CheckTimeout(time.Second))
+//
+// checks run in parallel when /readyz (or /livez for liveness checks) is probed
+//
+//	@param name
+//	@param check
+//	@param opts CheckLiveness, CheckTimeout, CheckCacheTTL
+func (b *KApi) AddHealthCheck(name string, check HealthCheck, opts ...HealthCheckOption) {
+	h := &healthCheck{name: name, check: check, timeout: 2 * time.Second, ttl: time.Second}
+	for _, opt := range opts {
+		opt(h)
+	}
+	b.healthLock.Lock()
+	defer b.healthLock.Unlock()
+	b.healthChecks = append(b.healthChecks, h)
+}
+
+// runHealthChecks run the checks in parallel
+//
+//	@return []healthResult in the order of names
+//	@return bool all passed
+func (b *KApi) runHealthChecks(ctx context.Context, liveness bool) ([]healthResult, bool) {
+	b.healthLock.Lock()
+	var checks []*healthCheck
+	for _, h := range b.healthChecks {
+		if !liveness || h.liveness {
+			checks = append(checks, h)
+		}
+	}
+	b.healthLock.Unlock()
+	results := make([]healthResult, len(checks))
+	var wg sync.WaitGroup
+	for i, h := range checks {
+		wg.Add(1)
+		go func(i int, h *healthCheck) {
+			defer wg.Done()
+			results[i] = h.run(ctx)
+		}(i, h)
+	}
+	wg.Wait()
+	ok := true
+	for _, r := range results {
+		ok = ok && r.Status == "ok"
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Name < results[j].Name
+	})
+	return results, ok
+}
+
+// healthHandler /livez or /readyz. authorized callers (see SetAdminAuth) get the result of every check as JSON,
+// others get ok or unavailable
+func (b *KApi) healthHandler(liveness bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var results []healthResult
+		ok := true
+		status := "ok"
+		if !liveness && b.draining.Load() {
+			ok, status = false, "draining"
+		} else {
+			results, ok = b.runHealthChecks(c.Request.Context(), liveness)
+			if !ok {
+				status = "unavailable"
+			}
+		}
+		code := http.StatusOK
+		if !ok {
+			code = http.StatusServiceUnavailable
+		}
+		c.Header("Cache-Control", "no-store")
+		if !b.adminAuthorized(c) {
+			c.String(code, status)
+			return
+		}
+		c.JSON(code, gin.H{"status": status, "checks": results})
+	}
+}
+
+// handleHealth serve /livez and /readyz, /healthz is the same as /livez
+func (b *KApi) handleHealth() {
+	b.engine.GET("/livez", b.healthHandler(true))
+	b.engine.GET("/readyz", b.healthHandler(false))
+	b.engine.GET("/healthz", b.healthHandler(true))
+}
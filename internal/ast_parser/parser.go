@@ -23,7 +23,7 @@ type Parser struct {
 	astPackages map[string]*ast.Package  //所有解析过的ast package
 	imports     map[string][]*importItem //包对应的所有导入信息，每个包一组，即包下所有文件的导入会在一组中
 	lock        sync.RWMutex
-	ignoreList  map[string]string
+	ignoreList  map[string]bool // key is importPath.TypeName
 	logger      parser_logger.ParserLogger
 }
 
@@ -57,8 +57,12 @@ func NewParser(modPkg, modFile string) (p *Parser) {
 		pkg:         "",
 		dir:         "",
 		astPackages: make(map[string]*ast.Package),
-		ignoreList: map[string]string{
-			"github.com/linxlib/kapi": "Context",
+		ignoreList: map[string]bool{
+			"github.com/linxlib/kapi.Context":      true,
+			"github.com/linxlib/kapi.WSConn":       true,
+			"github.com/linxlib/kapi.UploadedFile": true,
+			"github.com/linxlib/kapi.FileResponse": true,
+			"github.com/linxlib/kapi.PageQuery":    true,
 		},
 		logger: parser_logger.NewEmptyLogger(),
 	}
@@ -70,10 +74,7 @@ func NewParser(modPkg, modFile string) (p *Parser) {
 //	@param importPath
 //	@param t type name
 func (p *Parser) Ignore(importPath, t string) {
-	if _, ok := p.ignoreList[importPath]; ok {
-		return
-	}
-	p.ignoreList[importPath] = t
+	p.ignoreList[importPath+"."+t] = true
 }
 
 // parseField 递归解析结构体字段
@@ -141,7 +142,7 @@ func (p *Parser) handleParam(v *ast.Field, pkg string, importMP []*importItem) (
 	i1, isbuiltin, t1 := getImportAndType(a, param.PkgPath, importMP)
 	if !isbuiltin {
 		f1, err := p.Parse(i1, t1)
-		if err == nil {
+		if err == nil && f1 != nil && len(f1.Structs) > 0 {
 			param.Struct = f1.Structs[0]
 		} else {
 			if errors.Is(err, errIgnored) {
@@ -187,7 +188,7 @@ func (p *Parser) Parse(pkg, s string) (f *File, err error) {
 		return f, nil
 	}
 	//一些类型不进行解析
-	if a, ok := p.ignoreList[pkg]; ok && a == s {
+	if p.ignoreList[pkg+"."+s] {
 		return nil, errIgnored
 	}
 	p.logger.Infof("Parse %s %s", pkg, s)
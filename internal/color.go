@@ -33,42 +33,81 @@ func format(tag string) string {
 }
 
 func Infof(fmt string, args ...any) {
+	if handled(LevelInfo, fmt, args...) {
+		return
+	}
 	color.Printf(format(tinfo)+white(fmt)+"\n", args...)
 }
 func Info(args ...any) {
+	if handledPrint(LevelInfo, args...) {
+		return
+	}
 	color.Print(format(tinfo), white(args...)+"\n")
 }
 
 func Whitef(fmt string, args ...any) {
+	if handled(LevelInfo, fmt, args...) {
+		return
+	}
 	color.Printf(format(tnone)+white(fmt)+"\n", args...)
 }
 func Errorf(fmt string, args ...any) {
+	if handled(LevelError, fmt, args...) {
+		return
+	}
 	color.Printf(format(terr)+red(fmt)+"\n", args...)
 }
 func Error(fmt string, args ...any) {
+	if handledPrint(LevelError, args...) {
+		return
+	}
 	color.Printf(format(terr), red(args...)+"\n")
 }
 func Redf(fmt string, args ...any) {
+	if handled(LevelError, fmt, args...) {
+		return
+	}
 	color.Printf(format(tnone)+red(fmt)+"\n", args...)
 }
 func Debugf(fmt string, args ...any) {
+	if handled(LevelDebug, fmt, args...) {
+		return
+	}
 	color.Printf(format(tdebug)+green(fmt)+"\n", args...)
 }
 func Greenf(fmt string, args ...any) {
+	if handled(LevelInfo, fmt, args...) {
+		return
+	}
 	color.Printf(format(tnone)+green(fmt)+"\n", args...)
 }
 func Warnf(fmt string, args ...any) {
+	if handled(LevelWarn, fmt, args...) {
+		return
+	}
 	color.Printf(format(twarn)+yellow(fmt)+"\n", args...)
 }
 func Fatalf(fmt string, args ...any) {
+	if handled(LevelError, fmt, args...) {
+		return
+	}
 	color.Printf(format(twarn)+lightmagenta(fmt)+"\n", args...)
 }
 func OKf(fmt string, args ...any) {
+	if handled(LevelInfo, fmt, args...) {
+		return
+	}
 	color.Printf(format(tok)+green(fmt)+"\n", args...)
 }
 func Failf(fmt string, args ...any) {
+	if handled(LevelError, fmt, args...) {
+		return
+	}
 	color.Printf(format(tfail)+magenta(fmt)+"\n", args...)
 }
 func Yellowf(fmt string, args ...any) {
+	if handled(LevelWarn, fmt, args...) {
+		return
+	}
 	color.Printf(format(tnone)+yellow(fmt)+"\n", args...)
 }
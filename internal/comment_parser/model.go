@@ -18,7 +18,13 @@ type Comment struct {
 	//Name Summary.
 	Summary string // if empty, this field will be the Name of it
 	//@GET /api/v1/user/list.
+	//@SSE /api/v1/progress. GET route of server-sent events
+	//@WS /api/v1/chat. GET route upgraded to websocket
 	Routes map[string]string // will like map[route]HttpMethod
+	//@RATE 100/m by=ip.
+	Rate string // rate limit of current method, by=ip or by=header:X-API-Key
+	//@CACHE 30s vary=Authorization.
+	Cache string // cache the response of current method, GET only
 	//@Anonymous
 	Anonymous bool // current method will be anonymous even if `@AUTH` had been set to the controller. not implemented yet.
 	//@ROUTE /api/v1.
@@ -60,9 +60,13 @@ func (p *Parser) Parse(groupRoute string) *Comment {
 		case "@RESP":
 			mc.HasResp = true
 			mc.ResultType = strings.Split(comment, ".")
+		case "@RATE":
+			mc.Rate = comment
+		case "@CACHE":
+			mc.Cache = comment
 		case "@DESC":
 			mc.Description = append(mc.Description, comment) //we can have multiple @DESC to multiline description
-		case "@GET", "@POST", "@PUT", "@DELETE", "@PATCH", "@OPTIONS", "@HEAD":
+		case "@GET", "@POST", "@PUT", "@DELETE", "@PATCH", "@OPTIONS", "@HEAD", "@SSE", "@WS":
 			httpMethod := strings.ToUpper(strings.TrimPrefix(prefix, "@"))
 			routerPath := comment
 
@@ -243,6 +243,27 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+// AllowOrigin check if origin is allowed by AllowOrigins or AllowOriginFunc. AllowAllOrigins doesn't count,
+// it's for requests without credentials, and websocket handshakes always carry cookies
+func (c Config) AllowOrigin(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	origins := make([]string, 0, len(c.AllowOrigins))
+	for _, o := range c.AllowOrigins {
+		if strings.TrimSpace(o) != "*" {
+			origins = append(origins, o)
+		}
+	}
+	c.AllowOrigins = origins
+	cors := &cors{
+		allowOriginFunc: c.AllowOriginFunc,
+		allowOrigins:    normalize(c.AllowOrigins),
+		wildcardOrigins: c.parseWildcardRules(),
+	}
+	return cors.validateOrigin(strings.ToLower(origin))
+}
+
 func (c Config) parseWildcardRules() [][]string {
 	var wRules [][]string
 
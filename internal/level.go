@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+)
+
+// Level of kapi's log output, messages below it are dropped
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var (
+	level atomic.Int32
+	// SlogLevel follows SetLevel, it's the level of the default slog handlers
+	SlogLevel = new(slog.LevelVar)
+	logger    atomic.Pointer[slog.Logger]
+)
+
+var slogLevels = map[Level]slog.Level{
+	LevelDebug: slog.LevelDebug,
+	LevelInfo:  slog.LevelInfo,
+	LevelWarn:  slog.LevelWarn,
+	LevelError: slog.LevelError,
+}
+
+// ParseLevel debug, info, warn or error. empty is debug
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "", "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelDebug, fmt.Errorf("unknown log level %q", s)
+}
+
+// SetLevel set the level, it's safe to call while logging
+func SetLevel(l Level) {
+	level.Store(int32(l))
+	SlogLevel.Set(slogLevels[l])
+}
+
+// Enabled whether messages of l are logged
+func Enabled(l Level) bool {
+	return Level(level.Load()) <= l
+}
+
+// SetLogger send messages to l instead of printing them in color, nil prints in color again
+func SetLogger(l *slog.Logger) {
+	logger.Store(l)
+}
+
+// Logger returns the logger set by SetLogger, nil in the console
+func Logger() *slog.Logger {
+	return logger.Load()
+}
+
+// handled whether the message is dropped or sent to the slog logger, it's printed in color otherwise
+func handled(l Level, format string, args ...any) bool {
+	if !Enabled(l) {
+		return true
+	}
+	if sl := logger.Load(); sl != nil {
+		sl.Log(context.Background(), slogLevels[l], strings.TrimSpace(fmt.Sprintf(format, args...)))
+		return true
+	}
+	return false
+}
+
+// handledPrint same as handled, the message is formatted as fmt.Sprint
+func handledPrint(l Level, args ...any) bool {
+	return handled(l, "%s", fmt.Sprint(args...))
+}
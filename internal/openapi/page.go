@@ -0,0 +1,37 @@
+package openapi
+
+import (
+	"github.com/go-openapi/spec"
+	"github.com/linxlib/kapi/internal"
+	"github.com/linxlib/kapi/internal/ast_parser"
+	"strings"
+)
+
+// isPageQuery check if the field is an embedded kapi.PageQuery
+func isPageQuery(field *ast_parser.Field) bool {
+	return strings.TrimLeft(field.Type, "*") == "kapi.PageQuery"
+}
+
+// pageParams query params of kapi.PageQuery, limits and whitelists are read from the `page` tag
+func pageParams(field *ast_parser.Field) []*spec.Parameter {
+	tag := internal.ParsePageTag(field.GetTag("page"))
+	size, maxSize := tag.Size, tag.MaxSize
+	sorts, filters := internal.PageFieldNames(tag.Sorts), internal.PageFieldNames(tag.Filters)
+	params := []*spec.Parameter{
+		spec.QueryParam("page").Typed("integer", "int32").WithDefault(1).WithMinimum(1, false).
+			WithDescription("page number, starts from 1"),
+		spec.QueryParam("size").Typed("integer", "int32").WithDefault(size).WithMinimum(1, false).WithMaximum(float64(maxSize), false).
+			WithDescription("page size"),
+		spec.QueryParam("cursor").Typed("string", "").
+			WithDescription("cursor of cursor paging, page is ignored if set"),
+	}
+	if len(sorts) > 0 {
+		params = append(params, spec.QueryParam("sort").Typed("string", "").
+			WithDescription("comma separated fields, - for descending. fields: "+strings.Join(sorts, ", ")))
+	}
+	for _, f := range filters {
+		params = append(params, spec.QueryParam("filter["+f+"]").Typed("string", "").
+			WithDescription("filter by "+f+", use filter["+f+"][op] for other operators: eq ne gt gte lt lte like in"))
+	}
+	return params
+}
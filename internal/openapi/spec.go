@@ -1,6 +1,7 @@
 package openapi
 
 import (
+	"fmt"
 	"github.com/go-openapi/spec"
 	"github.com/linxlib/kapi/internal"
 	"github.com/linxlib/kapi/internal/ast_parser"
@@ -9,21 +10,74 @@ import (
 
 type Spec struct {
 	*Builder
+	consumes []string
+	produces []string
+	problem  bool
 }
 
 func NewSpec() *Spec {
 	return &Spec{
-		NewBuilder(),
+		Builder: NewBuilder(),
 	}
 }
+
+// SetMediaTypes set the consumes and produces of the operations added later
+func (myspec *Spec) SetMediaTypes(consumes []string, produces []string) {
+	myspec.consumes = consumes
+	myspec.produces = produces
+}
+
+// UseProblem document failures as RFC 7807 problem details
+func (myspec *Spec) UseProblem() {
+	myspec.problem = true
+	schema := spec.Schema{}
+	schema.WithDescription("RFC 7807 problem details").Typed("object", "")
+	schema.SetProperty("type", *spec.StringProperty().WithDescription("problem type uri"))
+	schema.SetProperty("title", *spec.StringProperty().WithDescription("summary of the problem type"))
+	schema.SetProperty("status", *spec.Int32Property().WithDescription("http status code"))
+	schema.SetProperty("detail", *spec.StringProperty().WithDescription("explanation of this occurrence"))
+	schema.SetProperty("instance", *spec.StringProperty().WithDescription("request uri of this occurrence"))
+	schema.AddRequired("type", "title", "status")
+	myspec.AddDefinitions("Problem", schema)
+}
+
 func (myspec *Spec) AddRoute(method string, path string, deprecated bool, summary string, tag string, requestParams []*spec.Parameter, responseParams []*spec.Response) {
+	sse := method == "SSE" // server-sent events are served by GET
+	ws := method == "WS"   // websocket is upgraded from GET
+	if sse || ws {
+		method = "GET"
+	}
 	op := spec.NewOperation(method + path)
 	op.Deprecated = deprecated
 	op.WithSummary(summary).WithTags(tag)
+	if method != "GET" && method != "HEAD" && len(myspec.consumes) > 0 {
+		op.WithConsumes(myspec.consumes...)
+	}
+	if len(myspec.produces) > 0 {
+		op.WithProduces(myspec.produces...)
+	}
+	if myspec.problem {
+		op.WithProduces("application/problem+json")
+	}
+	if sse {
+		op.Produces = []string{"text/event-stream"}
+		op.AddExtension("x-sse", true)
+	}
+	if ws {
+		op.Produces = nil
+		op.AddExtension("x-websocket", true)
+		op.WithDescription("websocket endpoint, upgrade with GET " + path)
+	}
 	for _, param := range requestParams {
+		if param.Type == "file" {
+			op.Consumes = []string{"multipart/form-data"}
+		}
 		op.AddParam(param)
 	}
 	for _, param := range responseParams {
+		if param.Schema != nil && param.Schema.Type.Contains("file") {
+			op.Produces = []string{"application/octet-stream"}
+		}
 		op.RespondsWith(200, param)
 	}
 
@@ -86,7 +140,96 @@ func (myspec *Spec) AddRoute(method string, path string, deprecated bool, summar
 	}
 }
 
+// operation the operation of method and path added by AddRoute, nil if not found
+func (myspec *Spec) operation(method string, path string) *spec.Operation {
+	if myspec.Swagger.Paths == nil {
+		return nil
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	item, ok := myspec.Swagger.Paths.Paths[replacePathTo(path)]
+	if !ok {
+		return nil
+	}
+	switch method {
+	case "GET", "SSE", "WS":
+		return item.Get
+	case "POST":
+		return item.Post
+	case "PUT":
+		return item.Put
+	case "DELETE":
+		return item.Delete
+	case "OPTIONS":
+		return item.Options
+	case "HEAD":
+		return item.Head
+	case "PATCH":
+		return item.Patch
+	}
+	return nil
+}
+
+// RateLimit document the @RATE of an operation as x-ratelimit and its 429 response
+//
+//	@param method
+//	@param path
+//	@param limit requests per window
+//	@param window seconds
+//	@param by ip or header:<name>
+func (myspec *Spec) RateLimit(method string, path string, limit int, window int, by string) {
+	op := myspec.operation(method, path)
+	if op == nil {
+		return
+	}
+	op.AddExtension("x-ratelimit", map[string]interface{}{"limit": limit, "window": window, "by": by})
+	resp := spec.NewResponse().
+		WithDescription(fmt.Sprintf("too many requests, %d per %ds by %s", limit, window, by)).
+		AddHeader("Retry-After", spec.ResponseHeader().Typed("integer", "").WithDescription("seconds until a request is allowed")).
+		AddHeader("RateLimit-Limit", spec.ResponseHeader().Typed("integer", "").WithDescription("requests per window")).
+		AddHeader("RateLimit-Remaining", spec.ResponseHeader().Typed("integer", "").WithDescription("requests left")).
+		AddHeader("RateLimit-Reset", spec.ResponseHeader().Typed("integer", "").WithDescription("seconds until the limit is reset"))
+	if myspec.problem {
+		resp.WithSchema(spec.RefSchema("#/definitions/Problem"))
+	}
+	op.RespondsWith(429, resp)
+}
+
+// Cache document the @CACHE of an operation as x-cache, its ETag and 304 response
+//
+//	@param method
+//	@param path
+//	@param ttl seconds
+//	@param vary request headers the response depends on
+func (myspec *Spec) Cache(method string, path string, ttl int, vary []string) {
+	op := myspec.operation(method, path)
+	if op == nil {
+		return
+	}
+	op.AddExtension("x-cache", map[string]interface{}{"ttl": ttl, "vary": vary})
+	op.AddParam(spec.HeaderParam("If-None-Match").Typed("string", "").
+		WithDescription("ETag of a cached response, 304 is returned if it is not modified"))
+	if op.Responses != nil {
+		if resp, ok := op.Responses.StatusCodeResponses[200]; ok {
+			resp.AddHeader("ETag", spec.ResponseHeader().Typed("string", "")).
+				AddHeader("Cache-Control", spec.ResponseHeader().Typed("string", "").WithDescription(fmt.Sprintf("max-age=%d", ttl)))
+			op.Responses.StatusCodeResponses[200] = resp
+		}
+	}
+	op.RespondsWith(304, spec.NewResponse().WithDescription("not modified"))
+}
+
 func (myspec *Spec) normalResponse(opBuilder *spec.Operation) {
+	if myspec.problem {
+		opBuilder.RespondsWith(400, spec.NewResponse().
+			WithDescription("parameter error or validation failed").
+			WithSchema(spec.RefSchema("#/definitions/Problem")))
+		opBuilder.WithDefaultResponse(spec.NewResponse().
+			WithDescription("application/problem+json").
+			WithSchema(spec.RefSchema("#/definitions/Problem")))
+		return
+	}
 	schema := &spec.Schema{}
 	schema.WithDescription("parameter error or validation failed\")")
 	p := &spec.Schema{}
@@ -123,12 +266,24 @@ func (myspec *Spec) ResponseParams(sResp *ast_parser.Struct) (responseParams []*
 	return responseParams
 }
 
+// FileResponses response of a method returns kapi.FileResponse or io.ReadSeeker
+func (myspec *Spec) FileResponses() []*spec.Response {
+	schema := new(spec.Schema).Typed("file", "")
+	return []*spec.Response{spec.NewResponse().WithDescription("file").WithSchema(schema)}
+}
+
 func (myspec *Spec) RequestParams(sReq *ast_parser.Struct) (requestParams []*spec.Parameter) {
 	requestParams = make([]*spec.Parameter, 0)
 	if sReq != nil {
 		// build json body scheme and add it into definitions
 		myspec.definitionSchema(sReq)
-		if len(sReq.GetAllFieldsByTag("json")) > 0 {
+		hasBody := false
+		for _, field := range sReq.GetAllFieldsByTag("json") {
+			if !isPageQuery(field) {
+				hasBody = true
+			}
+		}
+		if hasBody {
 			requestParams = append(requestParams,
 				spec.BodyParam(sReq.Name,
 					spec.RefSchema("#/definitions/"+sReq.Name)).
@@ -136,6 +291,11 @@ func (myspec *Spec) RequestParams(sReq *ast_parser.Struct) (requestParams []*spe
 					AsRequired())
 		}
 
+		for _, field := range sReq.Fields {
+			if isPageQuery(field) {
+				requestParams = append(requestParams, pageParams(field)...)
+			}
+		}
 		params := myspec.Parameter(sReq, "query")
 		if len(params) > 0 {
 			requestParams = append(requestParams, params...)
@@ -181,6 +341,14 @@ func (myspec *Spec) Parameter(sReq *ast_parser.Struct, tag string) (params []*sp
 	}
 
 	for _, field := range queryFields {
+		if tag == "form" && isFileType(field.Type) {
+			parameter := makeParam("file", field).WithDescription(field.Comment)
+			if field.GetTag("v") == "required" {
+				parameter.AsRequired()
+			}
+			params = append(params, parameter)
+			continue
+		}
 		parameter := makeParam(tag, field).
 			WithDescription(field.Comment)
 		if field.GetTag("v") == "required" {
@@ -229,6 +397,12 @@ func (myspec *Spec) Parameter(sReq *ast_parser.Struct, tag string) (params []*sp
 	return params
 }
 
+// isFileType multipart.FileHeader and kapi.UploadedFile fields are documented as file params
+func isFileType(t string) bool {
+	t = strings.TrimLeft(strings.TrimPrefix(t, "[]"), "*")
+	return t == "multipart.FileHeader" || t == "kapi.UploadedFile" || t == "UploadedFile"
+}
+
 func (myspec *Spec) definitionSchema(s *ast_parser.Struct) {
 	fds := s.GetAllFieldsByTag("json")
 	bodyDefineSchema := spec.Schema{}
@@ -245,6 +419,9 @@ func (myspec *Spec) definitionSchema(s *ast_parser.Struct) {
 		bodyDefineSchema.Typed("object", "") //request body or response body must be struct. should not be array
 
 		for _, field := range fds { //iter struct fields
+			if field.IsStruct && field.Struct == nil {
+				continue // types can not be parsed, e.g. kapi.PageQuery
+			}
 			fieldName := field.CurrentTag
 
 			bodyFieldSchema := spec.Schema{}
@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"strconv"
+	"strings"
+)
+
+// PageTag options of the `page` tag of kapi.PageQuery, e.g. `page:"size=20,maxSize=100,sort=name|createdAt:created_at,filter=name|age"`
+type PageTag struct {
+	Size    int // default 10, capped by MaxSize
+	MaxSize int // default 100
+	Sorts   []PageField
+	Filters []PageField
+}
+
+// PageField an item of a whitelist, name:column or name
+type PageField struct {
+	Name   string // name in the query
+	Column string // same as Name if not set
+}
+
+// ParsePageTag parse the `page` tag, invalid sizes are ignored
+//
+//	@param tag
+//
+//	@return PageTag
+func ParsePageTag(tag string) PageTag {
+	t := PageTag{Size: 10, MaxSize: 100}
+	whitelist := func(s string) []PageField {
+		var fields []PageField
+		for _, item := range strings.Split(s, "|") {
+			name, column, ok := strings.Cut(strings.TrimSpace(item), ":")
+			if name == "" {
+				continue
+			}
+			if !ok {
+				column = name
+			}
+			fields = append(fields, PageField{Name: name, Column: column})
+		}
+		return fields
+	}
+	for _, item := range strings.Split(tag, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(item), "=")
+		switch k {
+		case "size":
+			if n, err := strconv.Atoi(v); err == nil && n > 0 {
+				t.Size = n
+			}
+		case "maxSize":
+			if n, err := strconv.Atoi(v); err == nil && n > 0 {
+				t.MaxSize = n
+			}
+		case "sort":
+			t.Sorts = whitelist(v)
+		case "filter":
+			t.Filters = whitelist(v)
+		}
+	}
+	if t.Size > t.MaxSize {
+		t.Size = t.MaxSize
+	}
+	return t
+}
+
+// PageFieldNames names of the fields
+//
+//	@param fields
+//
+//	@return []string
+func PageFieldNames(fields []PageField) []string {
+	names := make([]string, 0, len(fields))
+	for _, f := range fields {
+		names = append(names, f.Name)
+	}
+	return names
+}
@@ -0,0 +1,29 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePageTag(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want PageTag
+	}{
+		{"", PageTag{Size: 10, MaxSize: 100}},
+		{"size=20,maxSize=50", PageTag{Size: 20, MaxSize: 50}},
+		{"size=200,maxSize=50", PageTag{Size: 50, MaxSize: 50}},
+		{"size=abc,maxSize=-1", PageTag{Size: 10, MaxSize: 100}},
+		{"sort=name|createdAt:created_at, filter=name| age", PageTag{
+			Size: 10, MaxSize: 100,
+			Sorts:   []PageField{{Name: "name", Column: "name"}, {Name: "createdAt", Column: "created_at"}},
+			Filters: []PageField{{Name: "name", Column: "name"}, {Name: "age", Column: "age"}},
+		}},
+		{"sort=|:x", PageTag{Size: 10, MaxSize: 100}},
+	}
+	for _, tt := range tests {
+		if got := ParsePageTag(tt.tag); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParsePageTag(%q) = %+v, want %+v", tt.tag, got, tt.want)
+		}
+	}
+}
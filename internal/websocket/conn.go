@@ -0,0 +1,391 @@
+// Package websocket a minimal RFC 6455 server side implementation, just enough for kapi's @WS routes
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// message types
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+)
+
+// close codes
+const (
+	CloseNormalClosure   = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseNoStatus        = 1005 // no status in the close frame, never sent
+	CloseInvalidPayload  = 1007
+	CloseMessageTooBig   = 1009
+	CloseInternalErr     = 1011
+	maxControlPayloadLen = 125
+)
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// CloseError returned by ReadMessage when a close frame is received
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: close %d %s", e.Code, e.Text)
+}
+
+var (
+	ErrReadLimit = errors.New("websocket: read limit exceeded")
+	// ErrBadOrigin returned by Upgrade if checkOrigin rejects the request
+	ErrBadOrigin = errors.New("websocket: origin not allowed")
+)
+
+// Conn a server side websocket connection
+type Conn struct {
+	conn         net.Conn
+	br           *bufio.Reader
+	wmu          sync.Mutex
+	readLimit    int64
+	writeTimeout time.Duration
+	pongHandler  func(data string) error
+	closeOnce    sync.Once
+	closeSent    bool
+}
+
+// SameOrigin check if Origin is missing, as it is for non-browser clients, or has the host of the request
+//
+//	@param r
+//
+//	@return bool
+func SameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// Upgrade upgrade the http connection to websocket
+//
+//	@param w
+//	@param r
+//	@param checkOrigin browsers send cookies of any page opening a websocket, so cross origin requests
+//	should be rejected unless allowed. SameOrigin if nil
+//
+//	@return *Conn
+//	@return error
+func Upgrade(w http.ResponseWriter, r *http.Request, checkOrigin func(r *http.Request) bool) (*Conn, error) {
+	if r.Method != http.MethodGet {
+		return nil, errors.New("websocket: method not GET")
+	}
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		return nil, errors.New("websocket: not a websocket handshake")
+	}
+	if r.Header.Get("Sec-Websocket-Version") != "13" {
+		return nil, errors.New("websocket: unsupported version")
+	}
+	key := r.Header.Get("Sec-Websocket-Key")
+	if k, err := base64.StdEncoding.DecodeString(key); err != nil || len(k) != 16 {
+		return nil, errors.New("websocket: bad Sec-WebSocket-Key")
+	}
+	if checkOrigin == nil {
+		checkOrigin = SameOrigin
+	}
+	if !checkOrigin(r) {
+		return nil, ErrBadOrigin
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("websocket: response does not implement http.Hijacker")
+	}
+	netConn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	h := sha1.New()
+	h.Write([]byte(key + acceptGUID))
+	accept := base64.StdEncoding.EncodeToString(h.Sum(nil))
+	resp := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " + accept + "\r\n\r\n"
+	if _, err := netConn.Write([]byte(resp)); err != nil {
+		_ = netConn.Close()
+		return nil, err
+	}
+	return newConn(netConn, brw.Reader), nil
+}
+
+func newConn(netConn net.Conn, br *bufio.Reader) *Conn {
+	return &Conn{
+		conn:         netConn,
+		br:           br,
+		readLimit:    1 << 20,
+		writeTimeout: 10 * time.Second,
+		pongHandler: func(string) error {
+			return nil
+		},
+	}
+}
+
+func headerContains(h http.Header, name string, value string) bool {
+	for _, v := range h.Values(name) {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SetReadLimit max bytes of a message
+func (c *Conn) SetReadLimit(limit int64) {
+	c.readLimit = limit
+}
+
+// SetWriteTimeout max time of writing a message, default 10s. a client which stops reading
+// fails writes after it instead of blocking them forever
+func (c *Conn) SetWriteTimeout(d time.Duration) {
+	c.writeTimeout = d
+}
+
+// SetReadDeadline see net.Conn
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetPongHandler called when a pong is received
+func (c *Conn) SetPongHandler(h func(data string) error) {
+	c.pongHandler = h
+}
+
+// RemoteAddr see net.Conn
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// ReadMessage read a text or binary message. pings are answered and pongs passed to pong handler.
+// a *CloseError is returned when client closes the connection
+func (c *Conn) ReadMessage() (messageType int, p []byte, err error) {
+	var buf []byte
+	msgType := 0
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch opcode {
+		case PingMessage:
+			if err := c.WriteControl(PongMessage, payload, time.Now().Add(time.Second)); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			if err := c.pongHandler(string(payload)); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case CloseMessage:
+			ce := &CloseError{Code: CloseNoStatus}
+			if len(payload) == 1 {
+				return 0, nil, c.protocolError("invalid close payload")
+			}
+			if len(payload) >= 2 {
+				ce.Code = int(binary.BigEndian.Uint16(payload))
+				ce.Text = string(payload[2:])
+				if !validCloseCode(ce.Code) {
+					return 0, nil, c.protocolError(fmt.Sprintf("invalid close code %d", ce.Code))
+				}
+				if !utf8.Valid(payload[2:]) {
+					return 0, nil, c.fail(CloseInvalidPayload, "invalid utf-8 in close reason")
+				}
+			}
+			_ = c.WriteControl(CloseMessage, FormatCloseMessage(ce.Code, ""), time.Now().Add(time.Second))
+			return 0, nil, ce
+		case TextMessage, BinaryMessage:
+			if msgType != 0 {
+				return 0, nil, c.protocolError("new message before the last one finished")
+			}
+			msgType = opcode
+		case 0: // continuation
+			if msgType == 0 {
+				return 0, nil, c.protocolError("continuation without message")
+			}
+		default:
+			return 0, nil, c.protocolError("unknown opcode")
+		}
+		if int64(len(buf)+len(payload)) > c.readLimit {
+			_ = c.WriteControl(CloseMessage, FormatCloseMessage(CloseMessageTooBig, ""), time.Now().Add(time.Second))
+			return 0, nil, ErrReadLimit
+		}
+		buf = append(buf, payload...)
+		if fin {
+			if msgType == TextMessage && !utf8.Valid(buf) {
+				return 0, nil, c.fail(CloseInvalidPayload, "invalid utf-8 in text message")
+			}
+			return msgType, buf, nil
+		}
+	}
+}
+
+// validCloseCode codes which may be sent in close frames, RFC 6455 section 7.4
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014:
+		return true
+	}
+	return code >= 3000 && code <= 4999
+}
+
+func (c *Conn) protocolError(msg string) error {
+	return c.fail(CloseProtocolError, msg)
+}
+
+// fail close with code and return the error of msg, RFC 6455 section 7.1.7
+func (c *Conn) fail(code int, msg string) error {
+	_ = c.WriteControl(CloseMessage, FormatCloseMessage(code, msg), time.Now().Add(time.Second))
+	return errors.New("websocket: " + msg)
+}
+
+func (c *Conn) readFrame() (fin bool, opcode int, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(c.br, head[:]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	opcode = int(head[0] & 0x0f)
+	if head[0]&0x70 != 0 {
+		// no extension is negotiated
+		err = c.protocolError("reserved bits set")
+		return
+	}
+	masked := head[1]&0x80 != 0
+	length := int64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		if ext[0]&0x80 != 0 {
+			err = c.protocolError("invalid payload length")
+			return
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+	if !masked {
+		err = c.protocolError("client frame not masked")
+		return
+	}
+	if opcode >= CloseMessage && (length > maxControlPayloadLen || !fin) {
+		err = c.protocolError("invalid control frame")
+		return
+	}
+	if length > c.readLimit {
+		_ = c.WriteControl(CloseMessage, FormatCloseMessage(CloseMessageTooBig, ""), time.Now().Add(time.Second))
+		err = ErrReadLimit
+		return
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+// WriteMessage write a text or binary message, it fails if not written in the write timeout
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.writeTimeout > 0 {
+		_ = c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+		defer c.conn.SetWriteDeadline(time.Time{})
+	}
+	return c.writeFrame(messageType, data)
+}
+
+// WriteControl write a control message with a deadline
+func (c *Conn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	if len(data) > maxControlPayloadLen {
+		data = data[:maxControlPayloadLen]
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return nil
+	}
+	_ = c.conn.SetWriteDeadline(deadline)
+	defer c.conn.SetWriteDeadline(time.Time{})
+	if messageType == CloseMessage {
+		c.closeSent = true
+	}
+	return c.writeFrame(messageType, data)
+}
+
+func (c *Conn) writeFrame(opcode int, data []byte) error {
+	head := make([]byte, 0, 10)
+	head = append(head, 0x80|byte(opcode))
+	switch n := len(data); {
+	case n < 126:
+		head = append(head, byte(n))
+	case n <= 0xffff:
+		head = append(head, 126, byte(n>>8), byte(n))
+	default:
+		head = append(head, 127)
+		head = binary.BigEndian.AppendUint64(head, uint64(n))
+	}
+	if _, err := c.conn.Write(head); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(data)
+	return err
+}
+
+// Close send a close frame and close the connection
+func (c *Conn) Close(code int, text string) error {
+	var err error
+	c.closeOnce.Do(func() {
+		_ = c.WriteControl(CloseMessage, FormatCloseMessage(code, text), time.Now().Add(time.Second))
+		err = c.conn.Close()
+	})
+	return err
+}
+
+// FormatCloseMessage payload of a close frame, empty for CloseNoStatus
+func FormatCloseMessage(code int, text string) []byte {
+	if code == CloseNoStatus {
+		return []byte{}
+	}
+	buf := make([]byte, 2, 2+len(text))
+	binary.BigEndian.PutUint16(buf, uint16(code))
+	return append(buf, text...)
+}
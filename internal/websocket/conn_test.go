@@ -0,0 +1,354 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// frame a frame written by the test client
+type frame struct {
+	fin      bool
+	rsv      byte
+	opcode   int
+	payload  []byte
+	unmasked bool
+	length   []byte // overrides the encoded length if set
+}
+
+func (f frame) encode() []byte {
+	var buf bytes.Buffer
+	b0 := byte(f.opcode) | f.rsv<<4
+	if f.fin {
+		b0 |= 0x80
+	}
+	buf.WriteByte(b0)
+	var mask byte = 0x80
+	if f.unmasked {
+		mask = 0
+	}
+	switch n := len(f.payload); {
+	case f.length != nil:
+		buf.WriteByte(mask | f.length[0])
+		buf.Write(f.length[1:])
+	case n < 126:
+		buf.WriteByte(mask | byte(n))
+	case n <= 0xffff:
+		buf.WriteByte(mask | 126)
+		_ = binary.Write(&buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(mask | 127)
+		_ = binary.Write(&buf, binary.BigEndian, uint64(n))
+	}
+	if f.unmasked {
+		buf.Write(f.payload)
+		return buf.Bytes()
+	}
+	key := [4]byte{0x12, 0x34, 0x56, 0x78}
+	buf.Write(key[:])
+	for i, c := range f.payload {
+		buf.WriteByte(c ^ key[i%4])
+	}
+	return buf.Bytes()
+}
+
+// readServerFrame read an unmasked frame written by the server
+func readServerFrame(r io.Reader) (frame, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return frame{}, err
+	}
+	f := frame{fin: head[0]&0x80 != 0, opcode: int(head[0] & 0x0f), unmasked: head[1]&0x80 == 0}
+	n := uint64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext uint16
+		if err := binary.Read(r, binary.BigEndian, &ext); err != nil {
+			return f, err
+		}
+		n = uint64(ext)
+	case 127:
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return f, err
+		}
+	}
+	f.payload = make([]byte, n)
+	_, err := io.ReadFull(r, f.payload)
+	return f, err
+}
+
+// pipe a server Conn and the frames it writes, collected until the client side is closed
+func pipe(t *testing.T) (*Conn, net.Conn, <-chan []frame) {
+	t.Helper()
+	server, client := net.Pipe()
+	c := newConn(server, bufio.NewReader(server))
+	written := make(chan []frame, 1)
+	go func() {
+		var frames []frame
+		for {
+			f, err := readServerFrame(client)
+			if err != nil {
+				written <- frames
+				return
+			}
+			frames = append(frames, f)
+		}
+	}()
+	t.Cleanup(func() {
+		_ = server.Close()
+		_ = client.Close()
+	})
+	return c, client, written
+}
+
+// closeCode the code of a close frame, CloseNoStatus if it has no payload
+func closeCode(f frame) int {
+	if f.opcode != CloseMessage {
+		return 0
+	}
+	if len(f.payload) < 2 {
+		return CloseNoStatus
+	}
+	return int(binary.BigEndian.Uint16(f.payload))
+}
+
+func TestReadMessage(t *testing.T) {
+	long := bytes.Repeat([]byte("a"), 70000)
+	tests := []struct {
+		name      string
+		frames    []frame
+		limit     int64
+		wantType  int
+		wantData  []byte
+		wantErr   string
+		wantClose int    // code of the close frame written by server
+		wantPong  []byte // payload of the pong written by server
+	}{
+		{name: "text", frames: []frame{{fin: true, opcode: TextMessage, payload: []byte("hello")}}, wantType: TextMessage, wantData: []byte("hello")},
+		{name: "binary", frames: []frame{{fin: true, opcode: BinaryMessage, payload: []byte{0, 1, 2}}}, wantType: BinaryMessage, wantData: []byte{0, 1, 2}},
+		{name: "empty", frames: []frame{{fin: true, opcode: TextMessage}}, wantType: TextMessage, wantData: nil},
+		{name: "16 bit length", frames: []frame{{fin: true, opcode: BinaryMessage, payload: long[:300]}}, wantType: BinaryMessage, wantData: long[:300]},
+		{name: "64 bit length", frames: []frame{{fin: true, opcode: BinaryMessage, payload: long}}, wantType: BinaryMessage, wantData: long},
+		{name: "fragmented", frames: []frame{
+			{opcode: TextMessage, payload: []byte("hel")},
+			{opcode: 0, payload: []byte("l")},
+			{fin: true, opcode: 0, payload: []byte("o")},
+		}, wantType: TextMessage, wantData: []byte("hello")},
+		{name: "ping between fragments", frames: []frame{
+			{opcode: TextMessage, payload: []byte("he")},
+			{fin: true, opcode: PingMessage, payload: []byte("p")},
+			{fin: true, opcode: 0, payload: []byte("llo")},
+		}, wantType: TextMessage, wantData: []byte("hello"), wantPong: []byte("p")},
+		{name: "close", frames: []frame{{fin: true, opcode: CloseMessage, payload: append([]byte{0x03, 0xe8}, "bye"...)}}, wantErr: "close 1000 bye", wantClose: CloseNormalClosure},
+		{name: "close without status", frames: []frame{{fin: true, opcode: CloseMessage}}, wantErr: "close 1005", wantClose: CloseNoStatus},
+		{name: "close with 1005", frames: []frame{{fin: true, opcode: CloseMessage, payload: []byte{0x03, 0xed}}}, wantErr: "invalid close code 1005", wantClose: CloseProtocolError},
+		{name: "close with 1006", frames: []frame{{fin: true, opcode: CloseMessage, payload: []byte{0x03, 0xee}}}, wantErr: "invalid close code 1006", wantClose: CloseProtocolError},
+		{name: "close with reserved code", frames: []frame{{fin: true, opcode: CloseMessage, payload: []byte{0x03, 0xec}}}, wantErr: "invalid close code 1004", wantClose: CloseProtocolError},
+		{name: "close with code out of range", frames: []frame{{fin: true, opcode: CloseMessage, payload: []byte{0x13, 0x88}}}, wantErr: "invalid close code 5000", wantClose: CloseProtocolError},
+		{name: "close with private code", frames: []frame{{fin: true, opcode: CloseMessage, payload: []byte{0x0f, 0xa0}}}, wantErr: "close 4000", wantClose: 4000},
+		{name: "close with invalid reason", frames: []frame{{fin: true, opcode: CloseMessage, payload: []byte{0x03, 0xe8, 0xff}}}, wantErr: "invalid utf-8", wantClose: CloseInvalidPayload},
+		{name: "invalid utf-8", frames: []frame{{fin: true, opcode: TextMessage, payload: []byte{'a', 0xc3, 0x28}}}, wantErr: "invalid utf-8", wantClose: CloseInvalidPayload},
+		{name: "utf-8 split across fragments", frames: []frame{
+			{opcode: TextMessage, payload: []byte("h\xc3")},
+			{fin: true, opcode: 0, payload: []byte("\xa9")},
+		}, wantType: TextMessage, wantData: []byte("hé")},
+		{name: "invalid utf-8 in binary", frames: []frame{{fin: true, opcode: BinaryMessage, payload: []byte{0xff}}}, wantType: BinaryMessage, wantData: []byte{0xff}},
+		{name: "close of one byte", frames: []frame{{fin: true, opcode: CloseMessage, payload: []byte{3}}}, wantErr: "invalid close payload", wantClose: CloseProtocolError},
+		{name: "unmasked", frames: []frame{{fin: true, opcode: TextMessage, payload: []byte("x"), unmasked: true}}, wantErr: "not masked", wantClose: CloseProtocolError},
+		{name: "reserved bits", frames: []frame{{fin: true, rsv: 4, opcode: TextMessage, payload: []byte("x")}}, wantErr: "reserved bits", wantClose: CloseProtocolError},
+		{name: "negative length", frames: []frame{{fin: true, opcode: BinaryMessage, length: []byte{127, 0x80, 0, 0, 0, 0, 0, 0, 1}}}, wantErr: "invalid payload length", wantClose: CloseProtocolError},
+		{name: "frame over limit", limit: 10, frames: []frame{{fin: true, opcode: TextMessage, payload: long[:11]}}, wantErr: ErrReadLimit.Error(), wantClose: CloseMessageTooBig},
+		{name: "message over limit", limit: 10, frames: []frame{
+			{opcode: TextMessage, payload: long[:6]},
+			{fin: true, opcode: 0, payload: long[:6]},
+		}, wantErr: ErrReadLimit.Error(), wantClose: CloseMessageTooBig},
+		{name: "long control frame", frames: []frame{{fin: true, opcode: PingMessage, payload: long[:126]}}, wantErr: "invalid control frame", wantClose: CloseProtocolError},
+		{name: "fragmented control frame", frames: []frame{{opcode: PingMessage, payload: []byte("p")}}, wantErr: "invalid control frame", wantClose: CloseProtocolError},
+		{name: "continuation without message", frames: []frame{{fin: true, opcode: 0, payload: []byte("x")}}, wantErr: "continuation without message", wantClose: CloseProtocolError},
+		{name: "message in message", frames: []frame{
+			{opcode: TextMessage, payload: []byte("a")},
+			{fin: true, opcode: TextMessage, payload: []byte("b")},
+		}, wantErr: "new message before", wantClose: CloseProtocolError},
+		{name: "unknown opcode", frames: []frame{{fin: true, opcode: 3}}, wantErr: "unknown opcode", wantClose: CloseProtocolError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, client, written := pipe(t)
+			if tt.limit > 0 {
+				c.SetReadLimit(tt.limit)
+			}
+			go func() {
+				for _, f := range tt.frames {
+					if _, err := client.Write(f.encode()); err != nil {
+						return
+					}
+				}
+			}()
+			typ, data, err := c.ReadMessage()
+			_ = c.conn.Close()
+			frames := <-written
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %s", err, tt.wantErr)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if typ != tt.wantType || !bytes.Equal(data, tt.wantData) {
+					t.Errorf("ReadMessage() = %d, %q, want %d, %q", typ, data, tt.wantType, tt.wantData)
+				}
+			}
+			var gotClose int
+			var gotPong []byte
+			for _, f := range frames {
+				if !f.unmasked {
+					t.Errorf("server frame is masked")
+				}
+				switch f.opcode {
+				case CloseMessage:
+					gotClose = closeCode(f)
+					if gotClose == CloseNoStatus && len(f.payload) > 0 {
+						t.Errorf("close code 1005 is sent")
+					}
+				case PongMessage:
+					gotPong = f.payload
+				}
+			}
+			if gotClose != tt.wantClose {
+				t.Errorf("close code = %d, want %d", gotClose, tt.wantClose)
+			}
+			if !bytes.Equal(gotPong, tt.wantPong) {
+				t.Errorf("pong = %q, want %q", gotPong, tt.wantPong)
+			}
+		})
+	}
+}
+
+func TestWriteMessage(t *testing.T) {
+	for _, n := range []int{0, 125, 126, 0xffff, 0x10000} {
+		c, client, written := pipe(t)
+		data := bytes.Repeat([]byte("x"), n)
+		if err := c.WriteMessage(BinaryMessage, data); err != nil {
+			t.Fatal(err)
+		}
+		_ = client.Close()
+		frames := <-written
+		if len(frames) != 1 {
+			t.Fatalf("%d bytes: %d frames written", n, len(frames))
+		}
+		if f := frames[0]; !f.fin || f.opcode != BinaryMessage || !f.unmasked || len(f.payload) != n {
+			t.Errorf("%d bytes: written fin=%v opcode=%d unmasked=%v len=%d", n, f.fin, f.opcode, f.unmasked, len(f.payload))
+		}
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	c := newConn(server, bufio.NewReader(server))
+	c.SetWriteTimeout(50 * time.Millisecond)
+	// the client never reads
+	err := c.WriteMessage(TextMessage, []byte("hello"))
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Fatalf("err = %v, want a timeout", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	c, _, written := pipe(t)
+	if err := c.Close(CloseGoingAway, "server shutdown"); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.Close(CloseNormalClosure, "")
+	frames := <-written
+	if len(frames) != 1 || closeCode(frames[0]) != CloseGoingAway || string(frames[0].payload[2:]) != "server shutdown" {
+		t.Errorf("written %+v, want one going away close frame", frames)
+	}
+	if err := c.WriteControl(PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+		t.Errorf("control frame after close: %v", err)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	var upgradeErr error
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var check func(*http.Request) bool
+		if r.URL.Query().Get("allow") != "" {
+			check = func(r *http.Request) bool {
+				return r.Header.Get("Origin") == "https://app.example.com"
+			}
+		}
+		conn, err := Upgrade(w, r, check)
+		upgradeErr = err
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		_ = conn.Close(CloseNormalClosure, "")
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+	tests := []struct {
+		name    string
+		path    string
+		header  map[string]string
+		status  int
+		wantErr error
+	}{
+		{name: "no origin", path: "/", status: 101},
+		{name: "same origin", path: "/", header: map[string]string{"Origin": "http://" + host}, status: 101},
+		{name: "cross origin", path: "/", header: map[string]string{"Origin": "https://evil.example.com"}, status: 403, wantErr: ErrBadOrigin},
+		{name: "allowed origin", path: "/?allow=1", header: map[string]string{"Origin": "https://app.example.com"}, status: 101},
+		{name: "rejected origin", path: "/?allow=1", header: map[string]string{"Origin": "http://" + host}, status: 403, wantErr: ErrBadOrigin},
+		{name: "bad key", path: "/", header: map[string]string{"Sec-WebSocket-Key": "short"}, status: 403},
+		{name: "bad version", path: "/", header: map[string]string{"Sec-WebSocket-Version": "8"}, status: 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", host)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			req, _ := http.NewRequest(http.MethodGet, srv.URL+tt.path, nil)
+			req.Header.Set("Connection", "Upgrade")
+			req.Header.Set("Upgrade", "websocket")
+			req.Header.Set("Sec-WebSocket-Version", "13")
+			req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			if err := req.Write(conn); err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.status == 101 {
+				// the accept key of RFC 6455 section 1.3
+				if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+					t.Errorf("Sec-WebSocket-Accept = %s", got)
+				}
+			}
+			if tt.wantErr != nil && !errors.Is(upgradeErr, tt.wantErr) {
+				t.Errorf("Upgrade() err = %v, want %v", upgradeErr, tt.wantErr)
+			}
+		})
+	}
+}
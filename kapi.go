@@ -1,6 +1,8 @@
 package kapi
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/linxlib/config"
@@ -8,9 +10,17 @@ import (
 	"github.com/linxlib/kapi/internal"
 	"github.com/linxlib/kapi/internal/openapi"
 	"github.com/linxlib/swagger_inject"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 // 编译时植入变量
@@ -50,45 +60,124 @@ type KApi struct {
 	doc        *openapi.Spec
 	routeInfo  *RouteInfo
 	inSource   bool
+	binders    []Binder
+	codecs     []Codec
+	messages   MessageCatalog
+	// resultBuilder builders copied to every Context
+	resultBuilder  *DefaultResultBuilder
+	errorMappings  []errorMappingItem
+	panicReporters []PanicReporter
+	server         *http.Server
+	wsConns        sync.Map // *WSConn of @WS routes, closed on shutdown
+	wsCheckOrigin  func(r *http.Request) bool
+	storage        Storage // where *UploadedFile are stored
+	providers      map[reflect.Type]*provider
+	mapped         []any    // values of Map and MapTo, their config fields are populated on register
+	cleanups       []func() // cleanups of singletons, run on shutdown
+	cleanupLock    sync.Mutex
+	singletonLock  sync.Mutex // held while singletons are made
+	// configSubscribers funcs of OnConfigChange, configLock also serializes reloads
+	configSubscribers []configSubscriber
+	configLock        sync.Mutex
+	done              chan struct{} // closed on shutdown
+	stopped           chan struct{} // closed when Shutdown finished, Run returns then
+	stopOnce          sync.Once
+	streamsDone       chan struct{} // closed on shutdown of the server, event streams end then
+	streamsOnce       sync.Once
+	metrics           *Registry
+	httpMetrics       *httpMetrics
+	routePaths        map[string]string    // gin full path -> RouterPath of registered routes
+	tracerProvider    trace.TracerProvider // tracing is disabled if nil
+	adminAuth         AdminAuth
+	adminServer       *http.Server // nil if admin routes are on the main port
+	startTime         time.Time
+	healthChecks      []*healthCheck
+	healthLock        sync.Mutex
+	draining          atomic.Bool   // readiness fails while shutting down
+	rateStore         RateStore     // token buckets of @RATE
+	responseCache     ResponseCache // responses of @CACHE
 }
 
 // New 创建新的KApi实例
 //
-//	@param f 配置函数
+//	@param f 配置函数, e.g. kapi.WithPort(8080), applied in order
 //
 //	@return *KApi
 func New(f ...func(*Option)) *KApi {
-	if VERSION != "" {
-		internal.Infof(_banner, fmt.Sprintf(_info, VERSION, GOVERSION, OS, ARCH, BUILDTIME, BUILDOS, BUILDARCH))
-	} else {
-		internal.Infof(_banner, "")
+	// messages before WithSlog applies
+	if l, console := defaultLogger(); !console {
+		internal.SetLogger(l)
 	}
-	internal.Info("start", PACKAGENAME)
-
 	b := &KApi{
-		Injector: inject.New(),
+		Injector:      inject.New(),
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+		streamsDone:   make(chan struct{}),
+		startTime:     time.Now(),
+		rateStore:     NewMemoryRateStore(),
+		responseCache: NewMemoryResponseCache(0),
 	}
 
 	if len(os.Args) > 1 && os.Args[1] == "-g" {
 		b.genFlag = true
 	}
 	b.option = defaultOption()
-	//TODO: many option func
-	if len(f) > 0 {
-		f[0](b.option)
+	for _, opt := range f {
+		opt(b.option)
+	}
+	// the config is read once, after options chose its source
+	readConfig(b.option)
+	b.option.setupLogger()
+	if b.option.console {
+		if VERSION != "" {
+			internal.Infof(_banner, fmt.Sprintf(_info, VERSION, GOVERSION, OS, ARCH, BUILDTIME, BUILDOS, BUILDARCH))
+		} else {
+			internal.Infof(_banner, "")
+		}
+	}
+	internal.Info("start ", PACKAGENAME)
+	// the logger of the request
+	_ = b.Provide(func(c *Context) *slog.Logger { return c.Logger() })
+	// the context of the request, with the current span
+	_ = b.Provide(func(c *Context) context.Context { return c.Request.Context() })
+	// the client forwarding the request ID
+	_ = b.Provide(func(c *Context) *http.Client { return c.HTTPClient() })
+	// evicts responses of @CACHE
+	_ = b.Provide(func(c *Context) *CacheInvalidator { return &CacheInvalidator{k: b, ctx: c.Request.Context()} })
+	// the current config, it may be reloaded
+	_ = b.ProvideTransient(b.option.Get)
+	b.binders = DefaultBinders()
+	b.codecs = DefaultCodecs()
+	b.messages = NewValidationMessages("zh")
+	b.resultBuilder = NewDefaultBuilder()
+	b.errorMappings = defaultErrorMappings()
+	b.SetStorage(NewLocalStorage(b.option.Server.Upload.Dir))
+	b.doc = openapi.NewSpec()
+	if b.option.Server.ErrorMode == "problem" {
+		b.resultBuilder = NewProblemBuilder()
+		b.doc.UseProblem()
 	}
-	b.Map(b.option.y)
 	b.routeInfo = NewRouteInfo()
 	if internal.FileIsExist("go.mod") {
 		b.inSource = true
 		b.routeInfo.Clean()
 	}
-	b.doc = openapi.NewSpec()
 	b.doc.WithInfo(b.option.Server.DocName, b.option.Server.DocVer, b.option.Server.DocDesc)
 	gin.SetMode(gin.ReleaseMode) //we don't need gin's debug output
 	b.engine = gin.New()
-	b.engine.Use(b.option.ginLoggerFormatter)
-	b.engine.Use(b.option.corsHandler)
+	b.option.trustProxies(b.engine)
+	b.engine.Use(b.requestIDHandler())
+	b.initTracing()
+	b.engine.Use(b.tracingHandler())
+	b.initMetrics()
+	if b.option.Server.Metrics.Enable {
+		b.engine.Use(b.metricsHandler())
+	}
+	b.engine.Use(b.recovery())
+	b.engine.Use(b.loggerHandler())
+	b.engine.Use(b.corsHandler())
+	b.engine.Use(b.option.middlewares...)
+	b.liveConfig()
 	if b.genFlag {
 		internal.Infof("generate mode")
 		b.inSource = true
@@ -99,14 +188,15 @@ func New(f ...func(*Option)) *KApi {
 	b.Map(b)
 	return b
 }
+
+// Settings returns the current config
+//
+//	@return *config.YAML
 func (b *KApi) Settings() *config.YAML {
-	a := new(config.YAML)
-	err := b.Provide(a)
-	if err != nil {
-		internal.Errorf("%s", err)
-		return a
+	if y := b.option.Get(); y != nil {
+		return y
 	}
-	return a
+	return new(config.YAML)
 }
 
 func (b *KApi) serverdown() {
@@ -196,14 +286,19 @@ func (b *KApi) Run() {
 		internal.OKf("generate mode complete!")
 		return
 	}
-	b.engine.GET("/healthz", func(context *gin.Context) {
-		context.String(200, "ok")
-	})
+	b.handleHealth()
 	b.serverdown()
+	b.handleMetrics()
+	b.handleAdmin()
 	b.handleStatic()
 	internal.Infof("server running http://%s:%d\n", b.option.intranetIP, b.option.Server.Port)
-	err := b.engine.Run(fmt.Sprintf(":%d", b.option.Server.Port))
-	if err != nil {
+	b.server = b.newServer(fmt.Sprintf(":%d", b.option.Server.Port))
+	go b.shutdownOnSignal()
+	b.runAdmin()
+	if b.option.Server.WatchConfig {
+		go b.watchConfig()
+	}
+	if err := b.serve(b.server.ListenAndServe); err != nil {
 		if e, ok := err.(*net.OpError); ok {
 			if e1, ok := e.Err.(*os.SyscallError); ok {
 				if e.Op == "listen" && e1.Syscall == "bind" {
@@ -215,3 +310,119 @@ func (b *KApi) Run() {
 		b.option.recoverErrorFunc(err)
 	}
 }
+
+// serve run the server by listen, which returns as soon as Shutdown starts. it waits for Shutdown to
+// finish requests and cleanups then
+//
+//	@param listen ListenAndServe or Serve of a listener
+//
+//	@return error nil if the server is shutdown
+func (b *KApi) serve(listen func() error) error {
+	err := listen()
+	if err == http.ErrServerClosed {
+		<-b.stopped
+		return nil
+	}
+	return err
+}
+
+// newServer the server of the engine, websockets and event streams are closed when it shuts down
+func (b *KApi) newServer(addr string) *http.Server {
+	s := &http.Server{Addr: addr, Handler: b.engine}
+	s.RegisterOnShutdown(b.closeWebSockets)
+	s.RegisterOnShutdown(b.closeEventStreams)
+	return s
+}
+
+// shutdownOnSignal shutdown the server gracefully on SIGINT or SIGTERM
+func (b *KApi) shutdownOnSignal() {
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	signal.Stop(quit)
+	timeout := time.Duration(b.option.Server.ShutdownTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := b.Shutdown(ctx); err != nil {
+		internal.Errorf("shutdown: %s", err)
+	}
+}
+
+// Shutdown stop the server gracefully. websocket connections are closed with "going away" and event streams end.
+// Run returns after requests finished and cleanups ran
+//
+//	@param ctx deadline of waiting for requests to finish, it starts after server.drainDelay
+//
+//	@return error
+func (b *KApi) Shutdown(ctx context.Context) error {
+	if b.server == nil {
+		return nil
+	}
+	select {
+	case <-b.done:
+	default:
+		close(b.done)
+	}
+	internal.Infof("shutting down...")
+	b.draining.Store(true)
+	ctx, cancel := b.drain(ctx)
+	defer cancel()
+	var adminErr error
+	var wg sync.WaitGroup
+	if b.adminServer != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			adminErr = b.adminServer.Shutdown(ctx)
+		}()
+	}
+	err := b.server.Shutdown(ctx)
+	wg.Wait()
+	err = errors.Join(err, adminErr)
+	b.runCleanups()
+	b.stopOnce.Do(func() {
+		close(b.stopped)
+	})
+	return err
+}
+
+// drain keep serving for server.drainDelay until load balancers see /readyz failing. the delay has its own
+// budget, the deadline of ctx is moved by the time spent, only cancelling ctx cuts it short
+//
+//	@param ctx
+//
+//	@return context.Context ctx of waiting for requests
+//	@return context.CancelFunc
+func (b *KApi) drain(ctx context.Context) (context.Context, context.CancelFunc) {
+	delay := time.Duration(b.option.Server.DrainDelay) * time.Second
+	if delay <= 0 {
+		return ctx, func() {}
+	}
+	start := time.Now()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			<-timer.C
+		}
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok || ctx.Err() == context.Canceled {
+		return ctx, func() {}
+	}
+	moved, cancel := context.WithDeadline(context.WithoutCancel(ctx), deadline.Add(time.Since(start)))
+	stop := context.AfterFunc(ctx, func() {
+		if ctx.Err() == context.Canceled {
+			cancel()
+		}
+	})
+	return moved, func() {
+		stop()
+		cancel()
+	}
+}
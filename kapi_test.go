@@ -0,0 +1,169 @@
+package kapi
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestKApi create a KApi without doc, its routes are added with route
+func newTestKApi(t *testing.T, f ...func(*Option)) *KApi {
+	t.Helper()
+	k := New(append([]func(*Option){WithoutDoc()}, f...)...)
+	t.Cleanup(func() {
+		select {
+		case <-k.done:
+		default:
+			close(k.done)
+		}
+	})
+	return k
+}
+
+// route add a GET or POST route of handler, handler is called like a controller method
+func (b *KApi) route(method string, path string, controller any, handler any) {
+	opts := routeOptions{route: method + " " + path}
+	b.engine.Handle(method, path, b.handle("Test.Handler", opts, controller, handler))
+}
+
+// serve the request with the engine and returns the response
+func serve(k *KApi, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	k.engine.ServeHTTP(w, req)
+	return w
+}
+
+func TestShutdownWaitsForRequests(t *testing.T) {
+	k := newTestKApi(t)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	k.route(http.MethodGet, "/slow", nil, func(c *Context) (any, error) {
+		close(started)
+		<-release
+		return "done", nil
+	})
+	var cleaned atomic.Bool
+	k.cleanups = append(k.cleanups, func() {
+		cleaned.Store(true)
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.server = &http.Server{Handler: k.engine}
+	served := make(chan error, 1)
+	go func() {
+		served <- k.serve(func() error { return k.server.Serve(ln) })
+	}()
+	resp := make(chan *http.Response, 1)
+	go func() {
+		r, err := http.Get("http://" + ln.Addr().String() + "/slow")
+		if err != nil {
+			t.Error(err)
+		}
+		resp <- r
+	}()
+	<-started
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdown <- k.Shutdown(ctx)
+	}()
+	select {
+	case err := <-served:
+		t.Fatalf("serve returned before the request finished: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	if err := <-served; err != nil {
+		t.Fatalf("serve: %v", err)
+	}
+	if !cleaned.Load() {
+		t.Error("serve returned before cleanups ran")
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+	if r := <-resp; r == nil || r.StatusCode != http.StatusOK {
+		t.Errorf("in-flight request was not finished: %v", r)
+	} else {
+		_ = r.Body.Close()
+	}
+}
+
+func TestShutdownDrainBudget(t *testing.T) {
+	k := newTestKApi(t, WithServer(func(s *ServerOption) {
+		s.DrainDelay = 1
+	}))
+	started := make(chan struct{})
+	k.route(http.MethodGet, "/slow", nil, func(c *Context) (any, error) {
+		close(started)
+		time.Sleep(1200 * time.Millisecond)
+		return "done", nil
+	})
+	listen := func(h http.Handler) (*http.Server, string, chan error) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := &http.Server{Handler: h}
+		served := make(chan error, 1)
+		go func() {
+			served <- srv.Serve(ln)
+		}()
+		return srv, ln.Addr().String(), served
+	}
+	var addr string
+	var served chan error
+	k.server, addr, served = listen(k.engine)
+	var adminServed chan error
+	k.adminServer, _, adminServed = listen(http.NotFoundHandler())
+	resp := make(chan *http.Response, 1)
+	go func() {
+		r, err := http.Get("http://" + addr + "/slow")
+		if err != nil {
+			t.Error(err)
+		}
+		resp <- r
+	}()
+	<-started
+	// the request needs longer than the timeout after the drain delay started, but not after it ended
+	ctx, cancel := context.WithTimeout(context.Background(), 800*time.Millisecond)
+	defer cancel()
+	if err := k.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+	if r := <-resp; r == nil || r.StatusCode != http.StatusOK {
+		t.Errorf("in-flight request was not finished: %v", r)
+	} else {
+		_ = r.Body.Close()
+	}
+	for _, ch := range []chan error{served, adminServed} {
+		if err := <-ch; err != http.ErrServerClosed {
+			t.Errorf("Serve: %v", err)
+		}
+	}
+}
+
+func TestShutdownDrainCanceled(t *testing.T) {
+	k := newTestKApi(t, WithServer(func(s *ServerOption) {
+		s.DrainDelay = 60
+	}))
+	k.server = &http.Server{Handler: k.engine}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	done := make(chan error, 1)
+	go func() {
+		done <- k.Shutdown(ctx)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("cancelling ctx did not cut the drain delay short")
+	}
+}
@@ -0,0 +1,116 @@
+package kapi
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/linxlib/kapi/internal"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// WithSlog log through l: kapi's messages and JSON-like access logs with method, route, status, latency,
+// bytes, client IP and request ID. without it, logs are printed in color in a terminal and as JSON otherwise
+//
+//	@param l
+//
+//	@return func(*Option)
+func WithSlog(l *slog.Logger) func(*Option) {
+	return func(o *Option) {
+		o.logger = l
+	}
+}
+
+// isConsole whether stdout is an interactive terminal
+func isConsole() bool {
+	fi, err := os.Stdout.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// defaultLogger a text logger in the console, JSON otherwise
+func defaultLogger() (l *slog.Logger, console bool) {
+	console = isConsole()
+	return newLogger(os.Stdout, console), console
+}
+
+// newLogger a text logger writing to w in the console, JSON otherwise
+func newLogger(w io.Writer, console bool) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: internal.SlogLevel}
+	if console {
+		return slog.New(slog.NewTextHandler(w, opts))
+	}
+	return slog.New(slog.NewJSONHandler(w, opts))
+}
+
+// setupLogger choose the logger. the color output of internal and the gin formatter of access logs
+// are only used in the console, unless a formatter is set by WithLogger
+func (o *Option) setupLogger() {
+	if o.logger == nil {
+		o.logger, o.console = defaultLogger()
+	}
+	if o.console && o.ginLoggerFormatter == nil {
+		o.ginLoggerFormatter = gin.LoggerWithFormatter(consoleLogFormatter)
+	}
+	if o.console {
+		internal.SetLogger(nil)
+	} else {
+		internal.SetLogger(o.logger)
+	}
+}
+
+// Logger returns the logger of kapi
+//
+//	@return *slog.Logger
+func (b *KApi) Logger() *slog.Logger {
+	return b.option.logger
+}
+
+// Logger returns the logger of the request, with its request ID, method and route. it can also be injected
+// as *slog.Logger
+//
+//	@return *slog.Logger
+func (c *Context) Logger() *slog.Logger {
+	if c.logger == nil {
+		c.logger = c.kapi.option.logger.With(
+			slog.String("request_id", requestID(c.Context)),
+			slog.String("method", c.Request.Method),
+			slog.String("route", c.FullPath()),
+		)
+	}
+	return c.logger
+}
+
+// loggerHandler access logs, they are skipped if the log level is above info.
+// they are printed by the gin formatter in the console or if set by WithLogger, and logged by slog otherwise
+func (b *KApi) loggerHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !internal.Enabled(internal.LevelInfo) {
+			c.Next()
+			return
+		}
+		if b.option.ginLoggerFormatter != nil {
+			b.option.ginLoggerFormatter(c)
+			return
+		}
+		start := time.Now()
+		c.Next()
+		level := slog.LevelInfo
+		if c.Writer.Status() >= 500 {
+			level = slog.LevelError
+		}
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("route", c.FullPath()),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", c.Writer.Status()),
+			slog.Duration("latency", time.Since(start)),
+			slog.Int("bytes", max(c.Writer.Size(), 0)),
+			slog.String("client_ip", c.ClientIP()),
+			slog.String("request_id", requestID(c)),
+		}
+		if msg := c.Errors.ByType(gin.ErrorTypePrivate).String(); msg != "" {
+			attrs = append(attrs, slog.String("error", msg))
+		}
+		b.option.logger.LogAttrs(c.Request.Context(), level, "access", attrs...)
+	}
+}
@@ -0,0 +1,149 @@
+package kapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// logRecords the JSON records in buf with the message msg
+func logRecords(t *testing.T, buf *bytes.Buffer, msg string) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if line == "" {
+			continue
+		}
+		var r map[string]any
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("record %q: %v", line, err)
+		}
+		if r["msg"] == msg {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+func TestAccessLog(t *testing.T) {
+	tests := []struct {
+		name  string
+		path  string
+		level string
+		want  map[string]any
+	}{
+		{"ok", "/items/1?x=1", "INFO",
+			map[string]any{"method": "GET", "route": "/items/:id", "path": "/items/1", "status": 200.0}},
+		{"error", "/items/fail", "ERROR",
+			map[string]any{"method": "GET", "route": "/items/:id", "path": "/items/fail", "status": 500.0}},
+		{"not found", "/missing", "INFO", map[string]any{"route": "", "path": "/missing", "status": 404.0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			k := newTestKApi(t, WithSlog(slog.New(slog.NewJSONHandler(&buf, nil))))
+			k.route(http.MethodGet, "/items/:id", nil, func(c *Context) (any, error) {
+				if c.Param("id") == "fail" {
+					return nil, errors.New("boom")
+				}
+				return "ok", nil
+			})
+			buf.Reset()
+			w := serve(k, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			records := logRecords(t, &buf, "access")
+			if len(records) != 1 {
+				t.Fatalf("access logs = %v, want one", records)
+			}
+			r := records[0]
+			if r["level"] != tt.level {
+				t.Errorf("level = %v, want %s", r["level"], tt.level)
+			}
+			for key, want := range tt.want {
+				if r[key] != want {
+					t.Errorf("%s = %v, want %v", key, r[key], want)
+				}
+			}
+			if r["request_id"] != w.Header().Get(HeaderRequestID) {
+				t.Errorf("request_id = %v, want %s", r["request_id"], w.Header().Get(HeaderRequestID))
+			}
+			for _, key := range []string{"latency", "bytes", "client_ip"} {
+				if _, ok := r[key]; !ok {
+					t.Errorf("no %s in %v", key, r)
+				}
+			}
+		})
+	}
+}
+
+func TestWithLogger(t *testing.T) {
+	var out, logs bytes.Buffer
+	old := gin.DefaultWriter
+	gin.DefaultWriter = &out
+	defer func() { gin.DefaultWriter = old }()
+	// a slog logger is not the console, the formatter is still used
+	k := newTestKApi(t, WithSlog(slog.New(slog.NewJSONHandler(&logs, nil))),
+		WithLogger(func(p gin.LogFormatterParams) string {
+			return "custom " + p.Method + " " + p.Path + "\n"
+		}))
+	k.route(http.MethodGet, "/x", nil, func(c *Context) (any, error) {
+		return "ok", nil
+	})
+	logs.Reset()
+	serve(k, httptest.NewRequest(http.MethodGet, "/x", nil))
+	if got := out.String(); got != "custom GET /x\n" {
+		t.Errorf("access log = %q, want the formatter", got)
+	}
+	if records := logRecords(t, &logs, "access"); len(records) != 0 {
+		t.Errorf("access logged by slog too: %v", records)
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	tests := []struct {
+		name    string
+		console bool
+		want    string
+	}{
+		{"console", true, "level=INFO msg=hi a=1"},
+		{"json", false, `"level":"INFO","msg":"hi","a":1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			newLogger(&buf, tt.console).Info("hi", "a", 1)
+			if !strings.Contains(buf.String(), tt.want) {
+				t.Errorf("log = %q, want %s", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestContextLogger(t *testing.T) {
+	var buf bytes.Buffer
+	k := newTestKApi(t, WithSlog(slog.New(slog.NewJSONHandler(&buf, nil))))
+	k.route(http.MethodPost, "/items/:id", nil, func(c *Context) (any, error) {
+		if c.Logger() != c.Logger() {
+			t.Error("a new logger for each call")
+		}
+		c.Logger().Info("handled", "id", c.Param("id"))
+		return "ok", nil
+	})
+	buf.Reset()
+	w := serve(k, httptest.NewRequest(http.MethodPost, "/items/1", nil))
+	records := logRecords(t, &buf, "handled")
+	if len(records) != 1 {
+		t.Fatalf("records = %v, want one", records)
+	}
+	want := map[string]any{"method": "POST", "route": "/items/:id", "id": "1", "request_id": w.Header().Get(HeaderRequestID)}
+	for key, v := range want {
+		if records[0][key] != v {
+			t.Errorf("%s = %v, want %v", key, records[0][key], v)
+		}
+	}
+}
@@ -0,0 +1,472 @@
+package kapi
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/linxlib/kapi/internal"
+	"io"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricSample a sample of a metric family
+type MetricSample struct {
+	Suffix string   // appended to the family name, e.g. _bucket
+	Labels []string // name, value pairs
+	Value  float64
+}
+
+// MetricFamily a metric in Prometheus text format
+type MetricFamily struct {
+	Name    string
+	Help    string
+	Type    string // counter, gauge, histogram or untyped
+	Samples []MetricSample
+}
+
+// Collector collects metric families when /metrics is scraped
+type Collector interface {
+	Collect() []MetricFamily
+}
+
+// CollectorFunc adapts a func to Collector
+type CollectorFunc func() []MetricFamily
+
+func (f CollectorFunc) Collect() []MetricFamily {
+	return f()
+}
+
+// metricVec series of a metric, keyed by label values
+type metricVec struct {
+	name   string
+	help   string
+	labels []string
+	lock   sync.Mutex
+	series map[string]*metricSeries
+}
+
+type metricSeries struct {
+	values  []string
+	value   float64
+	buckets []uint64 // histograms only, not cumulative
+	count   uint64
+}
+
+func newMetricVec(name string, help string, labels []string) metricVec {
+	return metricVec{name: name, help: help, labels: labels, series: make(map[string]*metricSeries)}
+}
+
+// get the series of values, the lock must be held
+func (v *metricVec) get(values []string) *metricSeries {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s: %d label values for labels %v", v.name, len(values), v.labels))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &metricSeries{values: append([]string(nil), values...)}
+		v.series[key] = s
+	}
+	return s
+}
+
+// sorted series sorted by label values, the lock must be held
+func (v *metricVec) sorted() []*metricSeries {
+	list := make([]*metricSeries, 0, len(v.series))
+	for _, s := range v.series {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return strings.Join(list[i].values, "\xff") < strings.Join(list[j].values, "\xff")
+	})
+	return list
+}
+
+func (v *metricVec) labelPairs(values []string, extra ...string) []string {
+	pairs := make([]string, 0, len(values)*2+len(extra))
+	for i, l := range v.labels {
+		pairs = append(pairs, l, values[i])
+	}
+	return append(pairs, extra...)
+}
+
+func (v *metricVec) collect(typ string) []MetricFamily {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	f := MetricFamily{Name: v.name, Help: v.help, Type: typ}
+	for _, s := range v.sorted() {
+		f.Samples = append(f.Samples, MetricSample{Labels: v.labelPairs(s.values), Value: s.value})
+	}
+	return []MetricFamily{f}
+}
+
+// Counter a counter with labels
+type Counter struct {
+	metricVec
+}
+
+// NewCounter create a counter, register it with k.Metrics().Register
+//
+//	@param name e.g. myapp_orders_total
+//	@param help
+//	@param labels label names
+//
+//	@return *Counter
+func NewCounter(name string, help string, labels ...string) *Counter {
+	return &Counter{newMetricVec(name, help, labels)}
+}
+
+// Add add delta to the series of label values
+//
+//	@param delta should not be negative
+//	@param values label values in the order of labels
+func (c *Counter) Add(delta float64, values ...string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.get(values).value += delta
+}
+
+// Inc add 1 to the series of label values
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *Counter) Collect() []MetricFamily {
+	return c.collect("counter")
+}
+
+// Gauge a gauge with labels
+type Gauge struct {
+	metricVec
+}
+
+// NewGauge create a gauge, register it with k.Metrics().Register
+//
+//	@param name
+//	@param help
+//	@param labels label names
+//
+//	@return *Gauge
+func NewGauge(name string, help string, labels ...string) *Gauge {
+	return &Gauge{newMetricVec(name, help, labels)}
+}
+
+// Set set the series of label values
+func (g *Gauge) Set(v float64, values ...string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.get(values).value = v
+}
+
+// Add add delta to the series of label values
+func (g *Gauge) Add(delta float64, values ...string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.get(values).value += delta
+}
+
+// Inc add 1
+func (g *Gauge) Inc(values ...string) {
+	g.Add(1, values...)
+}
+
+// Dec subtract 1
+func (g *Gauge) Dec(values ...string) {
+	g.Add(-1, values...)
+}
+
+func (g *Gauge) Collect() []MetricFamily {
+	return g.collect("gauge")
+}
+
+// DefBuckets default buckets of histograms in seconds
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Histogram a histogram with labels
+type Histogram struct {
+	metricVec
+	buckets []float64
+}
+
+// NewHistogram create a histogram, register it with k.Metrics().Register
+//
+//	@param name
+//	@param help
+//	@param buckets upper bounds in increasing order, DefBuckets if nil
+//	@param labels label names
+//
+//	@return *Histogram
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	return &Histogram{metricVec: newMetricVec(name, help, labels), buckets: buckets}
+}
+
+// Observe add an observation to the series of label values
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	s := h.get(values)
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(h.buckets))
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.buckets[i]++
+	}
+	s.count++
+	s.value += v
+}
+
+func (h *Histogram) Collect() []MetricFamily {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	f := MetricFamily{Name: h.name, Help: h.help, Type: "histogram"}
+	for _, s := range h.sorted() {
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += s.buckets[i]
+			f.Samples = append(f.Samples, MetricSample{Suffix: "_bucket", Labels: h.labelPairs(s.values, "le", formatFloat(le)), Value: float64(cumulative)})
+		}
+		f.Samples = append(f.Samples,
+			MetricSample{Suffix: "_bucket", Labels: h.labelPairs(s.values, "le", "+Inf"), Value: float64(s.count)},
+			MetricSample{Suffix: "_sum", Labels: h.labelPairs(s.values), Value: s.value},
+			MetricSample{Suffix: "_count", Labels: h.labelPairs(s.values), Value: float64(s.count)},
+		)
+	}
+	return []MetricFamily{f}
+}
+
+// Registry the collectors of /metrics
+type Registry struct {
+	lock       sync.RWMutex
+	collectors []Collector
+}
+
+// Register add collectors
+//
+//	@param c
+func (r *Registry) Register(c ...Collector) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.collectors = append(r.collectors, c...)
+}
+
+// Gather collect all metric families sorted by name
+//
+//	@return []MetricFamily
+func (r *Registry) Gather() []MetricFamily {
+	r.lock.RLock()
+	collectors := r.collectors
+	r.lock.RUnlock()
+	var families []MetricFamily
+	for _, c := range collectors {
+		families = append(families, c.Collect()...)
+	}
+	sort.SliceStable(families, func(i, j int) bool {
+		return families[i].Name < families[j].Name
+	})
+	return families
+}
+
+// WriteTo write all metrics in Prometheus text format 0.0.4
+//
+//	@param w
+//
+//	@return int64
+//	@return error
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: bufio.NewWriter(w)}
+	for _, f := range r.Gather() {
+		if f.Help != "" {
+			fmt.Fprintf(cw, "# HELP %s %s\n", f.Name, escapeHelp(f.Help))
+		}
+		if f.Type != "" {
+			fmt.Fprintf(cw, "# TYPE %s %s\n", f.Name, f.Type)
+		}
+		for _, s := range f.Samples {
+			cw.WriteString(f.Name + s.Suffix)
+			if len(s.Labels) > 0 {
+				cw.WriteString("{")
+				for i := 0; i+1 < len(s.Labels); i += 2 {
+					if i > 0 {
+						cw.WriteString(",")
+					}
+					cw.WriteString(s.Labels[i] + `="` + escapeLabel(s.Labels[i+1]) + `"`)
+				}
+				cw.WriteString("}")
+			}
+			cw.WriteString(" " + formatFloat(s.Value) + "\n")
+		}
+	}
+	err := cw.w.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP serve metrics in Prometheus text format
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = r.WriteTo(w)
+}
+
+type countWriter struct {
+	w *bufio.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countWriter) WriteString(s string) {
+	n, _ := c.w.WriteString(s)
+	c.n += int64(n)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
+
+// runtimeCollector Go runtime stats
+type runtimeCollector struct {
+	start time.Time
+}
+
+func (r runtimeCollector) Collect() []MetricFamily {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	gauge := func(name string, help string, v float64) MetricFamily {
+		return MetricFamily{Name: name, Help: help, Type: "gauge", Samples: []MetricSample{{Value: v}}}
+	}
+	return []MetricFamily{
+		gauge("go_goroutines", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine())),
+		{Name: "go_info", Help: "Information about the Go environment.", Type: "gauge", Samples: []MetricSample{{Labels: []string{"version", runtime.Version()}, Value: 1}}},
+		gauge("go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", float64(ms.Alloc)),
+		gauge("go_memstats_sys_bytes", "Number of bytes obtained from system.", float64(ms.Sys)),
+		gauge("go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use.", float64(ms.HeapInuse)),
+		gauge("go_memstats_heap_objects", "Number of allocated objects.", float64(ms.HeapObjects)),
+		gauge("go_memstats_next_gc_bytes", "Number of heap bytes when next garbage collection will take place.", float64(ms.NextGC)),
+		{Name: "go_memstats_alloc_bytes_total", Help: "Total number of bytes allocated, even if freed.", Type: "counter", Samples: []MetricSample{{Value: float64(ms.TotalAlloc)}}},
+		{Name: "go_gc_cycles_total", Help: "Number of completed GC cycles.", Type: "counter", Samples: []MetricSample{{Value: float64(ms.NumGC)}}},
+		{Name: "go_gc_pause_seconds_total", Help: "Total GC pause time.", Type: "counter", Samples: []MetricSample{{Value: float64(ms.PauseTotalNs) / 1e9}}},
+		gauge("go_threads", "Number of OS threads created.", float64(threadCount())),
+		gauge("process_start_time_seconds", "Start time of the process since unix epoch in seconds.", float64(r.start.UnixNano())/1e9),
+	}
+}
+
+func threadCount() int {
+	n, _ := runtime.ThreadCreateProfile(nil)
+	return n
+}
+
+// MetricsOption options of /metrics
+type MetricsOption struct {
+	// Enable serve /metrics and record request metrics
+	Enable bool `yaml:"enable"`
+	// Path default /metrics
+	Path string `yaml:"path"`
+	// Admin serve on the admin router instead of the main one, at <admin prefix>/metrics
+	Admin bool `yaml:"admin"`
+}
+
+// httpMetrics built-in metrics of requests
+type httpMetrics struct {
+	requests *Counter
+	duration *Histogram
+	inFlight *Gauge
+	panics   *Counter
+}
+
+func newHTTPMetrics() *httpMetrics {
+	return &httpMetrics{
+		requests: NewCounter("kapi_http_requests_total", "Number of HTTP requests.", "method", "route", "status"),
+		duration: NewHistogram("kapi_http_request_duration_seconds", "Latency of HTTP requests.", nil, "method", "route", "status"),
+		inFlight: NewGauge("kapi_http_requests_in_flight", "Number of HTTP requests being served.", "method", "route"),
+		panics:   NewCounter("kapi_panics_total", "Number of recovered panics.", "route"),
+	}
+}
+
+// Metrics returns the registry of /metrics, register your own collectors to it:
+//
+//	orders := kapi.NewCounter("myapp_orders_total", "Number of orders.", "status")
+//	k.Metrics().Register(orders)
+//	orders.Inc("paid")
+//
+//	@return *Registry
+func (b *KApi) Metrics() *Registry {
+	return b.metrics
+}
+
+// initMetrics create the registry with runtime stats and request metrics
+func (b *KApi) initMetrics() {
+	b.metrics = new(Registry)
+	b.httpMetrics = newHTTPMetrics()
+	b.metrics.Register(runtimeCollector{start: time.Now()}, b.httpMetrics.requests, b.httpMetrics.duration,
+		b.httpMetrics.inFlight, b.httpMetrics.panics)
+}
+
+// routeOf the route label of a request: RouterPath of kapi routes, the gin route of others, unmatched for 404
+func (b *KApi) routeOf(c *gin.Context) string {
+	full := c.FullPath()
+	if route, ok := b.routePaths[full]; ok {
+		return route
+	}
+	if full == "" {
+		return "unmatched"
+	}
+	return full
+}
+
+// metricsHandler record request metrics
+func (b *KApi) metricsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		method := c.Request.Method
+		route := b.routeOf(c)
+		b.httpMetrics.inFlight.Inc(method, route)
+		defer b.httpMetrics.inFlight.Dec(method, route)
+		c.Next()
+		status := strconv.Itoa(c.Writer.Status())
+		b.httpMetrics.requests.Inc(method, route, status)
+		b.httpMetrics.duration.Observe(time.Since(start).Seconds(), method, route, status)
+	}
+}
+
+// handleMetrics serve /metrics on the engine if enabled
+func (b *KApi) handleMetrics() {
+	opt := b.option.Server.Metrics
+	if !opt.Enable || opt.Admin {
+		return
+	}
+	path := opt.Path
+	if path == "" {
+		path = "/metrics"
+	}
+	b.engine.GET(path, gin.WrapH(b.metrics))
+	internal.Infof("metrics: http://%s:%d%s", b.option.intranetIP, b.option.Server.Port, path)
+}
@@ -0,0 +1,127 @@
+package kapi
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// scrape get the metrics endpoint and returns the samples by series, e.g. `name{label="value"}`
+func scrape(t *testing.T, url string) (map[string]float64, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := make(map[string]float64)
+	sc := bufio.NewScanner(strings.NewReader(string(body)))
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, "#") || line == "" {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		v, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			t.Fatalf("bad sample %q: %v", line, err)
+		}
+		samples[line[:i]] = v
+	}
+	return samples, string(body)
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	k := newTestKApi(t, WithServer(func(s *ServerOption) {
+		s.BasePath = "/api"
+		s.Metrics.Enable = true
+	}))
+	err := k.registerMethodToRouter(RouteItem{Method: "GET", RouterPath: "/users/:id"}, "Test.Get", nil,
+		func(c *Context) (any, error) {
+			return "ok", nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.handleMetrics()
+	hist := NewHistogram("test_latency_seconds", "Latency.", []float64{1, 2, 5}, "op")
+	for _, v := range []float64{0.5, 1.5, 1.5, 10} {
+		hist.Observe(v, "read")
+	}
+	escaped := NewCounter("test_escaped_total", "Help with \\ and\nnewline.", "value")
+	escaped.Inc("a\"b\\c\nd")
+	k.Metrics().Register(hist, escaped)
+	srv := httptest.NewServer(k.engine)
+	defer srv.Close()
+	for _, path := range []string{"/api/users/1", "/api/users/2", "/nowhere"} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+	}
+	samples, body := scrape(t, srv.URL+"/metrics")
+
+	tests := []struct {
+		series string
+		want   float64
+	}{
+		{`kapi_http_requests_total{method="GET",route="/users/:id",status="200"}`, 2},
+		{`kapi_http_requests_total{method="GET",route="unmatched",status="404"}`, 1},
+		{`kapi_http_request_duration_seconds_count{method="GET",route="/users/:id",status="200"}`, 2},
+		{`kapi_http_requests_in_flight{method="GET",route="/users/:id"}`, 0},
+		{`test_latency_seconds_bucket{op="read",le="1"}`, 1},
+		{`test_latency_seconds_bucket{op="read",le="2"}`, 3},
+		{`test_latency_seconds_bucket{op="read",le="5"}`, 3},
+		{`test_latency_seconds_bucket{op="read",le="+Inf"}`, 4},
+		{`test_latency_seconds_sum{op="read"}`, 13.5},
+		{`test_latency_seconds_count{op="read"}`, 4},
+		{`test_escaped_total{value="a\"b\\c\nd"}`, 1},
+	}
+	for _, tt := range tests {
+		if got, ok := samples[tt.series]; !ok || got != tt.want {
+			t.Errorf("%s = %v (found %v), want %v", tt.series, got, ok, tt.want)
+		}
+	}
+	if !strings.Contains(body, "# HELP test_escaped_total Help with \\\\ and\\nnewline.\n") {
+		t.Error("HELP is not escaped")
+	}
+	if !strings.Contains(body, "# TYPE test_latency_seconds histogram\n") {
+		t.Error("TYPE of histogram is missing")
+	}
+	for series := range samples {
+		if strings.Contains(series, `route="/api/users/:id"`) {
+			t.Errorf("route label is not the RouterPath: %s", series)
+		}
+	}
+
+	// buckets of request durations are cumulative and end with the count
+	var last float64
+	for _, b := range append(DefBuckets[:len(DefBuckets):len(DefBuckets)], math.Inf(1)) {
+		le := formatFloat(b)
+		series := `kapi_http_request_duration_seconds_bucket{method="GET",route="/users/:id",status="200",le="` + le + `"}`
+		v, ok := samples[series]
+		if !ok {
+			t.Fatalf("%s is missing", series)
+		}
+		if v < last {
+			t.Errorf("%s = %v, less than the previous bucket %v", series, v, last)
+		}
+		last = v
+	}
+	if last != 2 {
+		t.Errorf("+Inf bucket = %v, want 2", last)
+	}
+}
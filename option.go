@@ -6,8 +6,11 @@ import (
 	"github.com/linxlib/config"
 	"github.com/linxlib/kapi/internal"
 	"github.com/linxlib/kapi/internal/cors"
+	"log/slog"
 	"net"
 	"os"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -28,6 +31,37 @@ type ServerOption struct {
 	DocVer     string      `yaml:"docVer"`
 	StaticDirs []StaticDir `yaml:"staticDirs"`
 	Cors       cors.Config `yaml:"cors"`
+	// ErrorMode "problem" responds failures as RFC 7807 application/problem+json, otherwise {code,msg,count,data}
+	ErrorMode string `yaml:"errorMode"`
+	// Debug responds the detail of unmapped errors
+	Debug bool `yaml:"debug"`
+	// SSEHeartbeat seconds between heartbeats of event streams, default 15
+	SSEHeartbeat int `yaml:"sseHeartbeat"`
+	// WSPingInterval seconds between pings of websocket connections, default 30
+	WSPingInterval int `yaml:"wsPingInterval"`
+	// WSWriteTimeout seconds to write a websocket message, default 10
+	WSWriteTimeout int `yaml:"wsWriteTimeout"`
+	// ShutdownTimeout seconds to wait for requests to finish on shutdown, default 10
+	ShutdownTimeout int `yaml:"shutdownTimeout"`
+	// DrainDelay seconds to keep serving with /readyz failing before shutdown
+	DrainDelay int `yaml:"drainDelay"`
+	// Upload limits of streamed uploads
+	Upload UploadOption `yaml:"upload"`
+	// LogLevel debug, info, warn or error. access logs are info
+	LogLevel string `yaml:"logLevel"`
+	// RequestIDInBody add the request ID to error responses, requestId of {code,msg} or of problem details
+	RequestIDInBody bool `yaml:"requestIdInBody"`
+	// Metrics Prometheus metrics
+	Metrics MetricsOption `yaml:"metrics"`
+	// Tracing spans of requests
+	Tracing TracingOption `yaml:"tracing"`
+	// Admin pprof, route table, config and build info
+	Admin AdminOption `yaml:"admin"`
+	// WatchConfig reload the config file when it changes or on SIGHUP
+	WatchConfig bool `yaml:"watchConfig"`
+	// TrustedProxies IPs or CIDRs of proxies whose X-Forwarded-For gives the client IP, none by default.
+	// it's read at startup
+	TrustedProxies []string `yaml:"trustedProxies"`
 }
 
 var _defaultServerOption = ServerOption{
@@ -40,63 +74,146 @@ var _defaultServerOption = ServerOption{
 	StaticDirs: []StaticDir{
 		{Path: "static", Root: "static"},
 	},
-	Cors: cors.DefaultConfig(),
+	Cors:            cors.DefaultConfig(),
+	SSEHeartbeat:    15,
+	WSPingInterval:  30,
+	ShutdownTimeout: 10,
+	Upload: UploadOption{
+		MaxSize: 32 << 20,
+		Dir:     "uploads",
+	},
 }
 
 type Option struct {
 	ginLoggerFormatter gin.HandlerFunc
 	recoverErrorFunc   RecoverFunc
 	intranetIP         string
-	corsHandler        gin.HandlerFunc
-	y                  *config.YAML
+	corsHandler        atomic.Value // gin.HandlerFunc, swapped on reload
+	corsConfig         atomic.Pointer[cors.Config]
+	y                  atomic.Pointer[config.YAML]
+	configFile         string                // set by WithConfigFile
+	configSource       []byte                // set by WithConfigSource
+	configFiles        []string              // files of the config in use
+	overrides          []func(*ServerOption) // With... options, applied over the config
+	middlewares        []gin.HandlerFunc     // set by WithMiddleware
+	logger             *slog.Logger          // set by WithSlog
+	console            bool                  // logs are printed in color
+	profile            string                // KAPI_PROFILE
 	Server             ServerOption
 }
 
+// readConfig load the config file and the profile overlay selected by KAPI_PROFILE, then KAPI_ env vars.
+// defaults are used for missing keys, it's fine to have no file at all
 func readConfig(o *Option) *Option {
-	if internal.FileIsExist("config/config.yaml") {
-		conf, err := config.NewYAML(config.File("config/config.yaml"))
-		if err != nil {
-			internal.Errorf("%s", err)
+	o.profile = os.Getenv(envPrefix + "PROFILE")
+	server := _defaultServerOption
+	conf, files, err := o.loadConfig()
+	if err != nil {
+		internal.Errorf("%s", err)
+	} else {
+		if len(files) == 0 {
+			internal.Warnf("file %s not exist, use default options", o.configFilePath())
+		} else {
+			internal.Infof("config: %s", strings.Join(files, ", "))
 		}
-		err = conf.Get("server").Populate(&_defaultServerOption)
-		if err != nil {
+		if s, err := serverOption(conf); err != nil {
 			internal.Errorf("%s", err)
+		} else {
+			server = s
 		}
-		o.y = conf
-	} else {
-		internal.Warnf("file %s not exist, use default options", "config/config.yaml")
+		o.y.Store(conf)
+		o.configFiles = files
 	}
-	o.Server = _defaultServerOption
-	o.corsHandler = cors.New(o.Server.Cors)
+	o.Server = server
+	o.applyOverrides(&o.Server)
+	o.applyServer()
 
 	return o
 }
 
+// consoleLogFormatter the format of access logs in the console
+func consoleLogFormatter(param gin.LogFormatterParams) string {
+	var statusColor, methodColor, resetColor string
+	if param.IsOutputColor() {
+		statusColor = param.StatusCodeColor()
+		methodColor = param.MethodColor()
+		resetColor = param.ResetColor()
+	}
+
+	if param.Latency > time.Minute {
+		// Truncate in a golang < 1.8 safe way
+		param.Latency = param.Latency - param.Latency%time.Second
+	}
+	return fmt.Sprintf("%v |%s %3d %s| %13v | %15s |%s %-7s %s %#v (%s)\n%s",
+		param.TimeStamp.Format("01/02 - 15:04:05"),
+		statusColor, param.StatusCode, resetColor,
+		param.Latency,
+		param.ClientIP,
+		methodColor, param.Method, resetColor,
+		param.Path,
+		byteCountSI(int64(param.BodySize)),
+		param.ErrorMessage,
+	)
+}
+
+// applyOverrides apply the With... options over server options read from the config
+func (o *Option) applyOverrides(server *ServerOption) {
+	for _, f := range o.overrides {
+		f(server)
+	}
+}
+
+// applyServer apply the log level and CORS of o.Server
+func (o *Option) applyServer() {
+	level, _ := internal.ParseLevel(o.Server.LogLevel)
+	internal.SetLevel(level)
+	o.setCors(o.Server.Cors)
+}
+
+// trustProxies let engine take the client IP of X-Forwarded-For only from server.trustedProxies
+func (o *Option) trustProxies(engine *gin.Engine) {
+	if err := engine.SetTrustedProxies(o.Server.TrustedProxies); err != nil {
+		internal.Errorf("server.trustedProxies: %s", err)
+		_ = engine.SetTrustedProxies(nil)
+	}
+}
+
+// setCors swap the CORS handler and the config origins of websocket handshakes are checked with
+func (o *Option) setCors(c cors.Config) {
+	o.corsHandler.Store(cors.New(c))
+	o.corsConfig.Store(&c)
+}
+
+// override change server options, applied in order over the config when it's read and kept when it's reloaded
+func (o *Option) override(f func(*ServerOption)) {
+	o.overrides = append(o.overrides, f)
+}
+
+// serverOption populate the server section over the defaults and validate it
+func serverOption(conf *config.YAML) (ServerOption, error) {
+	server := _defaultServerOption
+	if conf.Get("server").HasValue() {
+		if err := conf.Get("server").Populate(&server); err != nil {
+			return server, err
+		}
+	}
+	if _, err := internal.ParseLevel(server.LogLevel); err != nil {
+		return server, err
+	}
+	c := server.Cors
+	if err := c.Validate(); err != nil {
+		return server, fmt.Errorf("server.cors: %w", err)
+	}
+	for _, p := range server.TrustedProxies {
+		if _, _, err := net.ParseCIDR(p); err != nil && net.ParseIP(p) == nil {
+			return server, fmt.Errorf("server.trustedProxies: %s is not an IP or CIDR", p)
+		}
+	}
+	return server, nil
+}
+
 func defaultOption() *Option {
 	o := &Option{
-		ginLoggerFormatter: gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
-			var statusColor, methodColor, resetColor string
-			if param.IsOutputColor() {
-				statusColor = param.StatusCodeColor()
-				methodColor = param.MethodColor()
-				resetColor = param.ResetColor()
-			}
-
-			if param.Latency > time.Minute {
-				// Truncate in a golang < 1.8 safe way
-				param.Latency = param.Latency - param.Latency%time.Second
-			}
-			return fmt.Sprintf("%v |%s %3d %s| %13v | %15s |%s %-7s %s %#v (%s)\n%s",
-				param.TimeStamp.Format("01/02 - 15:04:05"),
-				statusColor, param.StatusCode, resetColor,
-				param.Latency,
-				param.ClientIP,
-				methodColor, param.Method, resetColor,
-				param.Path,
-				byteCountSI(int64(param.BodySize)),
-				param.ErrorMessage,
-			)
-		}),
 		intranetIP: getIntranetIP(),
 		recoverErrorFunc: func(err interface{}) {
 			switch err {
@@ -107,7 +224,7 @@ func defaultOption() *Option {
 			}
 		},
 	}
-	return readConfig(o)
+	return o
 }
 
 // ByteCountSI 字节数转带单位
@@ -142,12 +259,20 @@ func getIntranetIP() string {
 	return "localhost"
 }
 
+// SetGinLoggerFormatter set the format of access logs, they are printed by it instead of slog
+// also outside the console
+//
+//	@param formatter
+//
+//	@return *Option
 func (o *Option) SetGinLoggerFormatter(formatter gin.LogFormatter) *Option {
 	o.ginLoggerFormatter = gin.LoggerWithFormatter(formatter)
 	return o
 }
+
+// Get returns the current config, it's replaced when the config is reloaded
 func (o *Option) Get() *config.YAML {
-	return o.y
+	return o.y.Load()
 }
 func (o *Option) SetRecoverFunc(f func(interface{})) *Option {
 	o.recoverErrorFunc = func(err interface{}) {
@@ -166,3 +291,126 @@ func (o *Option) SetIntranetIP(ip string) *Option {
 	o.intranetIP = ip
 	return o
 }
+
+// Profile returns the profile selected by KAPI_PROFILE, e.g. dev or prod
+func (o *Option) Profile() string {
+	return o.profile
+}
+
+// CorsConfig options of the built-in CORS handler
+type CorsConfig = cors.Config
+
+// WithServer change server options in code, the change overrides config values and is kept when
+// the config is reloaded
+//
+//	@param f
+//
+//	@return func(*Option)
+func WithServer(f func(s *ServerOption)) func(*Option) {
+	return func(o *Option) {
+		o.override(f)
+	}
+}
+
+// WithPort set the listening port
+//
+//	@param port
+//
+//	@return func(*Option)
+func WithPort(port int) func(*Option) {
+	return WithServer(func(s *ServerOption) {
+		s.Port = port
+	})
+}
+
+// WithBasePath set the base path of routes
+//
+//	@param path e.g. /api
+//
+//	@return func(*Option)
+func WithBasePath(path string) func(*Option) {
+	return WithServer(func(s *ServerOption) {
+		s.BasePath = path
+	})
+}
+
+// WithDoc serve the swagger doc with the info
+//
+//	@param name
+//	@param version
+//	@param desc
+//
+//	@return func(*Option)
+func WithDoc(name string, version string, desc string) func(*Option) {
+	return WithServer(func(s *ServerOption) {
+		s.NeedDoc = true
+		s.DocName = name
+		s.DocVer = version
+		s.DocDesc = desc
+	})
+}
+
+// WithoutDoc do not serve the swagger doc
+//
+//	@return func(*Option)
+func WithoutDoc() func(*Option) {
+	return WithServer(func(s *ServerOption) {
+		s.NeedDoc = false
+	})
+}
+
+// WithCors set the config of the built-in CORS handler
+//
+//	@param c
+//
+//	@return func(*Option)
+func WithCors(c CorsConfig) func(*Option) {
+	return WithServer(func(s *ServerOption) {
+		s.Cors = c
+	})
+}
+
+// WithLogLevel set the log level: debug, info, warn or error
+//
+//	@param level
+//
+//	@return func(*Option)
+func WithLogLevel(level string) func(*Option) {
+	return WithServer(func(s *ServerOption) {
+		s.LogLevel = level
+	})
+}
+
+// WithLogger set the format of access logs, they are printed to gin.DefaultWriter by it instead of slog,
+// also outside the console
+//
+//	@param formatter
+//
+//	@return func(*Option)
+func WithLogger(formatter gin.LogFormatter) func(*Option) {
+	return func(o *Option) {
+		o.SetGinLoggerFormatter(formatter)
+	}
+}
+
+// WithRecoverFunc set the func called with the error when the server fails
+//
+//	@param f
+//
+//	@return func(*Option)
+func WithRecoverFunc(f func(interface{})) func(*Option) {
+	return func(o *Option) {
+		o.SetRecoverFunc(f)
+	}
+}
+
+// WithMiddleware add middlewares to the engine, they run after recovery, logger and CORS
+//
+//	@param h
+//
+//	@return func(*Option)
+func WithMiddleware(h ...gin.HandlerFunc) func(*Option) {
+	return func(o *Option) {
+		o.middlewares = append(o.middlewares, h...)
+	}
+}
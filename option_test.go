@@ -0,0 +1,54 @@
+package kapi
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOptionsOrder(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(file, []byte("server:\n  port: 7000\n  basePath: /file\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	source := []byte("server:\n  port: 8000\n  docName: source\n")
+	doc := _defaultServerOption.DocName
+	tests := []struct {
+		name     string
+		opts     []func(*Option)
+		port     int
+		basePath string
+		docName  string
+	}{
+		{"later option wins", []func(*Option){WithPort(1), WithPort(2)}, 2, "", doc},
+		{"options in order", []func(*Option){WithPort(1), WithServer(func(s *ServerOption) { s.Port++ })}, 2, "", doc},
+		{"options over the config", []func(*Option){WithPort(1), WithConfigSource(source)}, 1, "", "source"},
+		{"later config wins", []func(*Option){WithConfigSource(source), WithConfigFile(file)}, 7000, "/file", doc},
+		{"later source wins", []func(*Option){WithConfigFile(file), WithConfigSource(source)}, 8000, "", "source"},
+		{"base path over the file", []func(*Option){WithBasePath("/api"), WithConfigFile(file)}, 7000, "/api", doc},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newTestKApi(t, append([]func(*Option){WithConfigSource([]byte("{}"))}, tt.opts...)...)
+			s := k.option.Server
+			if s.Port != tt.port || s.BasePath != tt.basePath || s.DocName != tt.docName {
+				t.Errorf("port, basePath, docName = %d, %q, %q, want %d, %q, %q",
+					s.Port, s.BasePath, s.DocName, tt.port, tt.basePath, tt.docName)
+			}
+		})
+	}
+}
+
+func TestOptionsDoNotReadConfig(t *testing.T) {
+	o := defaultOption()
+	for _, opt := range []func(*Option){WithConfigSource([]byte("a: 1\n")), WithConfigFile("config.yaml"), WithPort(1)} {
+		opt(o)
+	}
+	if o.Get() != nil || o.Server.Port != 0 {
+		t.Errorf("config read by options: %v, port %d", o.Get(), o.Server.Port)
+	}
+	readConfig(o)
+	if o.Get() == nil || o.Server.Port != 1 {
+		t.Errorf("config not read: %v, port %d", o.Get(), o.Server.Port)
+	}
+}
@@ -0,0 +1,453 @@
+package kapi
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/linxlib/kapi/internal"
+	"math"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PageQuery paging, sorting and filtering of a list request. embed it in the request param,
+// limits and whitelists are set by the `page` tag:
+//
+//	type ListUserReq struct {
+//		kapi.PageQuery `page:"size=20,maxSize=100,sort=name|createdAt:created_at,filter=name|age"`
+//	}
+//
+// supported query params:
+//
+//	page=2&size=20                   offset paging, page starts from 1. size is capped by maxSize
+//	cursor=xxx                       cursor paging, see EncodeCursor and DecodeCursor
+//	sort=name,-createdAt             sort by fields of the sort whitelist, - for descending
+//	filter[age][gte]=18&filter[name]=bob   filter by fields of the filter whitelist, op defaults to eq
+//
+// a whitelist item can be api:column, e.g. createdAt:created_at, Column of SortField and Filter is the column.
+// fields not in the whitelists fail the binding as validation errors
+type PageQuery struct {
+	page    int
+	size    int
+	cursor  string
+	sorts   []SortField
+	filters []Filter
+	url     url.URL
+}
+
+var pageQueryType = reflect.TypeOf(PageQuery{})
+
+// SortField a field of sort param
+type SortField struct {
+	Field  string // name in the query
+	Column string // column of the whitelist item, same as Field if not set
+	Desc   bool
+}
+
+// FilterOp operator of a filter
+type FilterOp string
+
+const (
+	FilterEq   FilterOp = "eq"
+	FilterNe   FilterOp = "ne"
+	FilterGt   FilterOp = "gt"
+	FilterGte  FilterOp = "gte"
+	FilterLt   FilterOp = "lt"
+	FilterLte  FilterOp = "lte"
+	FilterLike FilterOp = "like"
+	FilterIn   FilterOp = "in" // values are separated by comma
+)
+
+// filterOpSQL sql operators of filter ops
+var filterOpSQL = map[FilterOp]string{
+	FilterEq:   "=",
+	FilterNe:   "<>",
+	FilterGt:   ">",
+	FilterGte:  ">=",
+	FilterLt:   "<",
+	FilterLte:  "<=",
+	FilterLike: "LIKE",
+	FilterIn:   "IN",
+}
+
+// Filter a condition of filter[field][op]=value. all filters of a PageQuery are combined with AND
+type Filter struct {
+	Field  string // name in the query
+	Column string // column of the whitelist item, same as Field if not set
+	Op     FilterOp
+	Values []string // one value, or several for FilterIn
+}
+
+// Value returns the first value
+//
+//	@return string
+func (f Filter) Value() string {
+	if len(f.Values) == 0 {
+		return ""
+	}
+	return f.Values[0]
+}
+
+// Page returns the page number, starts from 1
+//
+//	@return int
+func (q *PageQuery) Page() int {
+	return q.page
+}
+
+// Size returns the page size
+//
+//	@return int
+func (q *PageQuery) Size() int {
+	return q.size
+}
+
+// Offset returns (page-1)*size
+//
+//	@return int
+func (q *PageQuery) Offset() int {
+	return (q.page - 1) * q.size
+}
+
+// Cursor returns the cursor param, empty if offset paging
+//
+//	@return string
+func (q *PageQuery) Cursor() string {
+	return q.cursor
+}
+
+// Sorts returns fields of sort param in order
+//
+//	@return []SortField
+func (q *PageQuery) Sorts() []SortField {
+	return q.sorts
+}
+
+// Filters returns the filters
+//
+//	@return []Filter
+func (q *PageQuery) Filters() []Filter {
+	return q.filters
+}
+
+// Filter returns the first filter of field
+//
+//	@param field name in the query
+//
+//	@return Filter
+//	@return bool
+func (q *PageQuery) Filter(field string) (Filter, bool) {
+	for _, f := range q.filters {
+		if f.Field == field {
+			return f, true
+		}
+	}
+	return Filter{}, false
+}
+
+// OrderBy returns sorts as sql, e.g. "name ASC, created_at DESC". columns come from the whitelist, so it is safe to concat
+//
+//	@return string
+func (q *PageQuery) OrderBy() string {
+	items := make([]string, 0, len(q.sorts))
+	for _, s := range q.sorts {
+		if s.Desc {
+			items = append(items, s.Column+" DESC")
+		} else {
+			items = append(items, s.Column+" ASC")
+		}
+	}
+	return strings.Join(items, ", ")
+}
+
+// likeEscaper escapes wildcards of LIKE values with !, which is the escape char in ESCAPE '!'
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// Where returns filters as sql with ? placeholders, e.g. "age >= ? AND name IN (?,?)".
+// like matches the value anywhere, its % and _ are literal: "name LIKE ? ESCAPE '!'" with "%bob%"
+//
+//	@return string
+//	@return []any
+func (q *PageQuery) Where() (string, []any) {
+	items := make([]string, 0, len(q.filters))
+	args := make([]any, 0, len(q.filters))
+	for _, f := range q.filters {
+		if f.Op == FilterLike {
+			items = append(items, f.Column+" LIKE ? ESCAPE '!'")
+			args = append(args, "%"+likeEscaper.Replace(f.Value())+"%")
+			continue
+		}
+		if f.Op == FilterIn {
+			items = append(items, f.Column+" IN ("+strings.TrimSuffix(strings.Repeat("?,", len(f.Values)), ",")+")")
+			for _, v := range f.Values {
+				args = append(args, v)
+			}
+			continue
+		}
+		items = append(items, f.Column+" "+filterOpSQL[f.Op]+" ?")
+		args = append(args, f.Value())
+	}
+	return strings.Join(items, " AND "), args
+}
+
+// DecodeCursor decode the cursor param made by EncodeCursor
+//
+//	@param v pointer
+//
+//	@return error
+func (q *PageQuery) DecodeCursor(v any) error {
+	bs, err := base64.RawURLEncoding.DecodeString(q.cursor)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bs, v)
+}
+
+// EncodeCursor encode v as an opaque cursor, e.g. the sort key of the last item
+//
+//	@param v
+//
+//	@return string
+func EncodeCursor(v any) string {
+	bs, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(bs)
+}
+
+// Result returns a page of offset paging, the links are made from the request url
+//
+//	@param items
+//	@param total count of all items
+//
+//	@return *Page
+func (q *PageQuery) Result(items any, total int64) *Page {
+	p := &Page{Items: items, Total: total, Links: PageLinks{Self: q.link("page", q.page)}}
+	if int64(q.page*q.size) < total {
+		p.Links.Next = q.link("page", q.page+1)
+	}
+	if q.page > 1 {
+		p.Links.Prev = q.link("page", q.page-1)
+	}
+	return p
+}
+
+// CursorResult returns a page of cursor paging
+//
+//	@param items
+//	@param next cursor of the next page, empty if it's the last page
+//	@param prev cursor of the previous page, empty if it's the first page
+//
+//	@return *Page
+func (q *PageQuery) CursorResult(items any, next string, prev string) *Page {
+	p := &Page{Items: items, Total: -1, Links: PageLinks{Self: q.link("", nil)}}
+	if next != "" {
+		p.Links.Next = q.link("cursor", next)
+	}
+	if prev != "" {
+		p.Links.Prev = q.link("cursor", prev)
+	}
+	return p
+}
+
+// link the request url with key replaced
+func (q *PageQuery) link(key string, value any) string {
+	u := q.url
+	if key != "" {
+		query := u.Query()
+		query.Set(key, fmt.Sprint(value))
+		if key == "cursor" {
+			query.Del("page")
+		} else {
+			query.Set("size", strconv.Itoa(q.size)) // the capped size
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u.RequestURI()
+}
+
+// Page a page of items. return it from a controller method to respond through OnPage,
+// the links are also written as Link header
+type Page struct {
+	Items any
+	Total int64 // -1 if unknown, e.g. cursor paging
+	Links PageLinks
+}
+
+// PageLinks links of a page, relative to the host
+type PageLinks struct {
+	Self string `json:"self"`
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
+// pageOption options of the `page` tag
+type pageOption struct {
+	size    int
+	maxSize int
+	sorts   map[string]string // field → column
+	filters map[string]string
+}
+
+// parsePageTag parse `page:"size=20,maxSize=100,sort=name|createdAt:created_at,filter=name|age"`
+func parsePageTag(tag string) pageOption {
+	t := internal.ParsePageTag(tag)
+	opt := pageOption{size: t.Size, maxSize: t.MaxSize, sorts: map[string]string{}, filters: map[string]string{}}
+	for _, f := range t.Sorts {
+		opt.sorts[f.Name] = f.Column
+	}
+	for _, f := range t.Filters {
+		opt.filters[f.Name] = f.Column
+	}
+	return opt
+}
+
+var filterKey = regexp.MustCompile(`^filter\[([^\[\]]+)\](?:\[([^\[\]]+)\])?$`)
+
+// parse read the query of the request
+func (q *PageQuery) parse(c *Context, opt pageOption) error {
+	query := c.Request.URL.Query()
+	*q = PageQuery{page: 1, size: opt.size, cursor: query.Get("cursor"), url: *c.Request.URL}
+	var errs ValidationErrors
+	if v := query.Get("page"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			q.page = n
+		} else {
+			errs = append(errs, ValidationError{Field: "page", Rule: "min", Param: "1", Message: "page must be a number greater than 0"})
+		}
+	}
+	if v := query.Get("size"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			q.size = n
+		} else {
+			errs = append(errs, ValidationError{Field: "size", Rule: "min", Param: "1", Message: "size must be a number greater than 0"})
+		}
+	}
+	if q.size > opt.maxSize {
+		q.size = opt.maxSize
+	}
+	if q.size > 0 && q.page > math.MaxInt/q.size {
+		// the offset would overflow
+		max := strconv.Itoa(math.MaxInt / q.size)
+		errs = append(errs, ValidationError{Field: "page", Rule: "max", Param: max, Message: "page must not be greater than " + max})
+	}
+	if v := query.Get("sort"); v != "" {
+		for _, item := range strings.Split(v, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			sf := SortField{Field: strings.TrimLeft(item, "+-"), Desc: strings.HasPrefix(item, "-")}
+			column, ok := opt.sorts[sf.Field]
+			if !ok {
+				errs = append(errs, ValidationError{Field: "sort", Rule: "oneof", Param: whitelistParam(opt.sorts), Message: "can not sort by " + sf.Field})
+				continue
+			}
+			sf.Column = column
+			q.sorts = append(q.sorts, sf)
+		}
+	}
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		values := query[key]
+		m := filterKey.FindStringSubmatch(key)
+		if m == nil {
+			continue
+		}
+		f := Filter{Field: m[1], Op: FilterOp(m[2])}
+		if f.Op == "" {
+			f.Op = FilterEq
+		}
+		column, ok := opt.filters[f.Field]
+		if !ok {
+			errs = append(errs, ValidationError{Field: key, Rule: "oneof", Param: whitelistParam(opt.filters), Message: "can not filter by " + f.Field})
+			continue
+		}
+		if _, ok := filterOpSQL[f.Op]; !ok {
+			errs = append(errs, ValidationError{Field: key, Rule: "oneof", Param: "eq ne gt gte lt lte like in", Message: "unknown filter operator " + string(f.Op)})
+			continue
+		}
+		f.Column = column
+		for _, v := range values {
+			if f.Op == FilterIn {
+				f.Values = append(f.Values, strings.Split(v, ",")...)
+			} else {
+				f.Values = append(f.Values, v)
+			}
+		}
+		q.filters = append(q.filters, f)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func whitelistParam(m map[string]string) string {
+	fields := make([]string, 0, len(m))
+	for k := range m {
+		fields = append(fields, k)
+	}
+	sort.Strings(fields)
+	return strings.Join(fields, " ")
+}
+
+type pageBinder struct{}
+
+func (pageBinder) Name() string {
+	return "page"
+}
+
+// Bind fill PageQuery and *PageQuery fields of the request param
+func (pageBinder) Bind(c *Context, obj any) error {
+	v := reflect.Indirect(reflect.ValueOf(obj))
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		field := v.Field(i)
+		switch {
+		case sf.Type == pageQueryType:
+		case sf.Type.Kind() == reflect.Ptr && sf.Type.Elem() == pageQueryType:
+			if !field.CanSet() {
+				continue
+			}
+			if field.IsNil() {
+				field.Set(reflect.New(pageQueryType))
+			}
+			field = field.Elem()
+		default:
+			continue
+		}
+		if !field.CanAddr() {
+			continue
+		}
+		if err := field.Addr().Interface().(*PageQuery).parse(c, parsePageTag(sf.Tag.Get("page"))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// respondPage respond a page through OnPage, links are also written as Link header
+func (c *Context) respondPage(p *Page) {
+	var links []string
+	if p.Links.Next != "" {
+		links = append(links, "<"+p.Links.Next+`>; rel="next"`)
+	}
+	if p.Links.Prev != "" {
+		links = append(links, "<"+p.Links.Prev+`>; rel="prev"`)
+	}
+	if len(links) > 0 {
+		c.Header("Link", strings.Join(links, ", "))
+	}
+	c.Respond(c.OnPage("", p))
+}
@@ -0,0 +1,59 @@
+package kapi
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"math"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestPageQuery(t *testing.T) {
+	const tag = "size=20,maxSize=50,sort=name|createdAt:created_at,filter=name|age|title:post_title"
+	const lastPage = math.MaxInt / 50
+	tests := []struct {
+		name    string
+		query   string
+		orderBy string
+		where   string
+		args    []any
+		size    int
+		offset  int
+		errs    int
+	}{
+		{name: "defaults", query: "", size: 20, where: "", args: []any{}},
+		{name: "paging", query: "page=3&size=10", size: 10, offset: 20, args: []any{}},
+		{name: "capped size", query: "size=1000", size: 50, args: []any{}},
+		{name: "sort", query: "sort=-createdAt,name", size: 20, orderBy: "created_at DESC, name ASC", args: []any{}},
+		{name: "filters", query: "filter[age][gte]=18&filter[name]=bob&filter[title][in]=a,b", size: 20,
+			where: "age >= ? AND name = ? AND post_title IN (?,?)", args: []any{"18", "bob", "a", "b"}},
+		{name: "like", query: "filter[name][like]=bob", size: 20, where: "name LIKE ? ESCAPE '!'", args: []any{"%bob%"}},
+		{name: "like wildcards", query: "filter[name][like]=50%25_off!", size: 20, where: "name LIKE ? ESCAPE '!'", args: []any{"%50!%!_off!!%"}},
+		{name: "bad page", query: "page=0&size=x", errs: 2},
+		{name: "last page", query: "page=" + strconv.Itoa(lastPage) + "&size=50", size: 50, offset: (lastPage - 1) * 50, args: []any{}},
+		{name: "offset overflow", query: "page=" + strconv.Itoa(lastPage+1) + "&size=50", errs: 1},
+		{name: "max page", query: "page=" + strconv.Itoa(math.MaxInt), errs: 1},
+		{name: "not whitelisted", query: "sort=password&filter[password]=x&filter[age][regex]=1", errs: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Context{Context: &gin.Context{Request: httptest.NewRequest("GET", "/users?"+tt.query, nil)}}
+			var q PageQuery
+			err := q.parse(c, parsePageTag(tag))
+			var errs ValidationErrors
+			errors.As(err, &errs)
+			if len(errs) != tt.errs || (err != nil) != (tt.errs > 0) {
+				t.Fatalf("parse() err = %v, want %d validation errors", err, tt.errs)
+			}
+			if tt.errs > 0 {
+				return
+			}
+			where, args := q.Where()
+			if q.Size() != tt.size || q.Offset() != tt.offset || q.OrderBy() != tt.orderBy || where != tt.where || !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("size %d offset %d order %q where %q %v", q.Size(), q.Offset(), q.OrderBy(), where, args)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package kapi
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin/render"
+	"net/http"
+)
+
+// MIMEProblemJSON content type of RFC 7807 problem details
+const MIMEProblemJSON = "application/problem+json"
+
+// Problem RFC 7807 problem details. Extensions are written as top level members
+type Problem struct {
+	Type       string         `json:"type"`
+	Title      string         `json:"title"`
+	Status     int            `json:"status"`
+	Detail     string         `json:"detail,omitempty"`
+	Instance   string         `json:"instance,omitempty"` // set to the request uri if empty
+	Extensions map[string]any `json:"-"`
+}
+
+// NewProblem create a problem of status, type is about:blank and title is the status text
+//
+//	@param status http status code
+//	@param detail
+//
+//	@return *Problem
+func NewProblem(status int, detail string) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// With add an extension member
+//
+//	@param key
+//	@param value
+//
+//	@return *Problem
+func (p *Problem) With(key string, value any) *Problem {
+	if p.Extensions == nil {
+		p.Extensions = make(map[string]any)
+	}
+	p.Extensions[key] = value
+	return p
+}
+
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	m := make(map[string]any, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		m[k] = v
+	}
+	m["type"] = p.Type
+	m["title"] = p.Title
+	m["status"] = p.Status
+	if p.Detail != "" {
+		m["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		m["instance"] = p.Instance
+	}
+	return json.Marshal(m)
+}
+
+// problemRender writes a Problem as application/problem+json
+type problemRender struct {
+	problem *Problem
+}
+
+func (r problemRender) Render(w http.ResponseWriter) error {
+	r.WriteContentType(w)
+	bs, err := json.Marshal(r.problem)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(bs)
+	return err
+}
+
+func (r problemRender) WriteContentType(w http.ResponseWriter) {
+	header := w.Header()
+	if val := header["Content-Type"]; len(val) == 0 {
+		header["Content-Type"] = []string{MIMEProblemJSON}
+	}
+}
+
+var _ render.Render = problemRender{}
+
+// NewProblemBuilder returns result builders which respond failures as RFC 7807 problem details,
+// OnSuccess and OnData are the same as NewDefaultBuilder
+//
+//	@return *DefaultResultBuilder
+func NewProblemBuilder() *DefaultResultBuilder {
+	builder := NewDefaultBuilder()
+	builder.OnFail = func(msg string, data any) (statusCode int, result any) {
+		p := NewProblem(400, msg)
+		if data != nil {
+			p.With("data", data)
+		}
+		return 400, p
+	}
+	builder.OnError = func(msg string, err error) (statusCode int, result any) {
+		return 500, NewProblem(500, msg)
+	}
+	builder.OnErrorDetail = func(msg string, err any) (statusCode int, result any) {
+		p := NewProblem(500, msg)
+		if err != nil {
+			p.With("error", err)
+		}
+		return 500, p
+	}
+	builder.OnNoPermission = func(msg string) (statusCode int, result any) {
+		return 403, NewProblem(403, msg)
+	}
+	builder.OnNotFound = func(msg string) (statusCode int, result any) {
+		return 404, NewProblem(404, msg)
+	}
+	builder.OnUnAuthed = func(msg string) (statusCode int, result any) {
+		return 401, NewProblem(401, msg)
+	}
+	builder.OnValidationFail = func(msg string, errs ValidationErrors) (statusCode int, result any) {
+		return 400, NewProblem(400, msg).With("errors", errs)
+	}
+	builder.OnStatus = func(status int, code int, msg string) (statusCode int, result any) {
+		p := NewProblem(status, msg)
+		if code != 0 {
+			p.With("code", code)
+		}
+		return status, p
+	}
+	return builder
+}
+
+// SetResultBuilder replace all result builders
+//
+//	@param builder NewDefaultBuilder or NewProblemBuilder
+func (b *KApi) SetResultBuilder(builder *DefaultResultBuilder) {
+	b.resultBuilder = builder
+}
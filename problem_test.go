@@ -0,0 +1,104 @@
+package kapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestProblemJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		problem *Problem
+		want    map[string]any
+	}{
+		{
+			name:    "defaults",
+			problem: NewProblem(404, ""),
+			want:    map[string]any{"type": "about:blank", "title": "Not Found", "status": 404.0},
+		},
+		{
+			name:    "extensions",
+			problem: NewProblem(400, "bad").With("code", 7).With("errors", []string{"a"}),
+			want: map[string]any{"type": "about:blank", "title": "Bad Request", "status": 400.0, "detail": "bad",
+				"code": 7.0, "errors": []any{"a"}},
+		},
+		{
+			name:    "members win over extensions",
+			problem: (&Problem{Type: "https://example.com/t", Title: "T", Status: 409, Instance: "/x"}).With("status", 1),
+			want:    map[string]any{"type": "https://example.com/t", "title": "T", "status": 409.0, "instance": "/x"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bs, err := json.Marshal(tt.problem)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got map[string]any
+			if err := json.Unmarshal(bs, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("json = %s, want %v", bs, tt.want)
+			}
+		})
+	}
+}
+
+func TestProblemResponses(t *testing.T) {
+	k := newTestKApi(t, WithServer(func(s *ServerOption) {
+		s.RequestIDInBody = true
+	}))
+	k.SetResultBuilder(NewProblemBuilder())
+	k.route(http.MethodGet, "/fail", nil, func(c *Context) (any, error) {
+		return nil, errors.New("boom")
+	})
+	k.route(http.MethodGet, "/custom", nil, func(c *Context) {
+		c.Respond(http.StatusConflict, (&Problem{Type: "https://example.com/conflict", Title: "Conflict", Status: 409, Instance: "/own"}).With("id", "1"))
+	})
+	k.route(http.MethodGet, "/status", nil, func(c *Context) {
+		c.Respond(c.OnStatus(http.StatusTooManyRequests, 42, "slow down"))
+	})
+	tests := []struct {
+		path   string
+		accept string
+		status int
+		want   map[string]any // members checked in the body
+	}{
+		{"/fail?x=1", "", 500, map[string]any{"status": 500.0, "title": "Internal Server Error", "instance": "/fail?x=1"}},
+		{"/fail", "application/xml", 500, map[string]any{"status": 500.0, "instance": "/fail"}},
+		{"/custom", "", 409, map[string]any{"type": "https://example.com/conflict", "instance": "/own", "id": "1"}},
+		{"/status", "", 429, map[string]any{"detail": "slow down", "code": 42.0, "instance": "/status"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := serve(k, req)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != MIMEProblemJSON {
+				t.Errorf("Content-Type = %q, want %s", ct, MIMEProblemJSON)
+			}
+			var body map[string]any
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("body %s: %v", w.Body, err)
+			}
+			for key, want := range tt.want {
+				if !reflect.DeepEqual(body[key], want) {
+					t.Errorf("%s = %v, want %v", key, body[key], want)
+				}
+			}
+			if body["requestId"] != w.Header().Get(HeaderRequestID) {
+				t.Errorf("requestId = %v, want %s", body["requestId"], w.Header().Get(HeaderRequestID))
+			}
+		})
+	}
+}
@@ -0,0 +1,492 @@
+package kapi
+
+import (
+	"fmt"
+	"github.com/linxlib/inject"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Lifetime how long a value made by a provider lives
+type Lifetime int
+
+const (
+	// LifetimeRequest made once per request, cleaned up when the request ends
+	LifetimeRequest Lifetime = iota
+	// LifetimeSingleton made once, cleaned up on shutdown
+	LifetimeSingleton
+	// LifetimeTransient made every time it is asked for, cleaned up with the request (or on shutdown outside of requests)
+	LifetimeTransient
+)
+
+var (
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	cleanupType = reflect.TypeOf(func() {})
+)
+
+// ProviderError the error returned by a provider function. it is responded like an error returned by
+// the controller method, so error mappings apply
+type ProviderError struct {
+	Type reflect.Type
+	Err  error
+}
+
+func (e *ProviderError) Error() string {
+	return fmt.Sprintf("provider of %v: %v", e.Type, e.Err)
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// provider a registered provider function
+type provider struct {
+	fn       reflect.Value
+	typ      reflect.Type // type of the value it makes
+	lifetime Lifetime
+
+	lock  sync.Mutex // guards value of singletons
+	value reflect.Value
+}
+
+// newProvider check the signature: func(deps...) T, (T, error), (T, func()) or (T, func(), error)
+func newProvider(f any, lifetime Lifetime) (*provider, error) {
+	fn := reflect.ValueOf(f)
+	t := fn.Type()
+	if t.Kind() != reflect.Func {
+		return nil, fmt.Errorf("provider should be a function: %v", t)
+	}
+	ok := t.NumOut() >= 1 && t.NumOut() <= 3
+	for i := 1; ok && i < t.NumOut(); i++ {
+		switch t.Out(i) {
+		case cleanupType:
+			ok = i == 1
+		case errorType:
+			ok = i == t.NumOut()-1
+		default:
+			ok = false
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("provider should return T, (T, error), (T, func()) or (T, func(), error): %v", t)
+	}
+	return &provider{fn: fn, typ: t.Out(0), lifetime: lifetime}, nil
+}
+
+// call the provider, deps are resolved by value. `inject:"config:<path>"` fields of the value are populated by k
+func (p *provider) call(k *KApi, value func(reflect.Type) (reflect.Value, error)) (reflect.Value, func(), error) {
+	t := p.fn.Type()
+	in := make([]reflect.Value, t.NumIn())
+	for i := range in {
+		v, err := value(t.In(i))
+		if err != nil {
+			return reflect.Value{}, nil, err
+		}
+		in[i] = v
+	}
+	out := p.fn.Call(in)
+	var cleanup func()
+	for _, o := range out[1:] {
+		switch o.Type() {
+		case cleanupType:
+			if !o.IsNil() {
+				cleanup = o.Interface().(func())
+			}
+		case errorType:
+			if !o.IsNil() {
+				if cleanup != nil {
+					cleanup()
+				}
+				return reflect.Value{}, nil, &ProviderError{Type: p.typ, Err: o.Interface().(error)}
+			}
+		}
+	}
+	if err := k.applyConfig(out[0].Interface()); err != nil {
+		if cleanup != nil {
+			cleanup()
+		}
+		return reflect.Value{}, nil, &ProviderError{Type: p.typ, Err: err}
+	}
+	return out[0], cleanup, nil
+}
+
+// Provide register a provider function of request lifetime, e.g. a transaction per request:
+//
+//	k.Provide(func(c *kapi.Context, db *sql.DB) (*sql.Tx, func(), error) {
+//		tx, err := db.BeginTx(c.Request.Context(), nil)
+//		if err != nil {
+//			return nil, nil, err
+//		}
+//		return tx, func() { _ = tx.Rollback() }, nil
+//	})
+//
+// the value is made lazily when a method param or an `inject:""` field asks for it, and the cleanup func
+// runs when the request ends. if i is not a function, it is filled from the mapped values as before
+//
+//	@param i provider function, or a pointer to fill
+//
+//	@return error
+func (b *KApi) Provide(i any) error {
+	if i != nil && reflect.TypeOf(i).Kind() == reflect.Func {
+		return b.ProvideWith(i, LifetimeRequest)
+	}
+	return provideWith(i, b.resolve)
+}
+
+// ProvideSingleton register a provider function whose value is made once. it can not depend on request values
+//
+//	@param f provider function
+//
+//	@return error
+func (b *KApi) ProvideSingleton(f any) error {
+	return b.ProvideWith(f, LifetimeSingleton)
+}
+
+// ProvideTransient register a provider function whose value is made every time it is asked for
+//
+//	@param f provider function
+//
+//	@return error
+func (b *KApi) ProvideTransient(f any) error {
+	return b.ProvideWith(f, LifetimeTransient)
+}
+
+// ProvideWith register a provider function with a lifetime
+//
+//	@param f func(deps...) T, (T, error), (T, func()) or (T, func(), error)
+//	@param lifetime
+//
+//	@return error
+func (b *KApi) ProvideWith(f any, lifetime Lifetime) error {
+	p, err := newProvider(f, lifetime)
+	if err != nil {
+		return err
+	}
+	if b.providers == nil {
+		b.providers = make(map[reflect.Type]*provider)
+	}
+	b.providers[p.typ] = p
+	return nil
+}
+
+// Value returns the mapped value of t, or makes it by a singleton or transient provider
+//
+//	@param t
+//
+//	@return reflect.Value zero if not found
+func (b *KApi) Value(t reflect.Type) reflect.Value {
+	v, _ := b.resolve(t)
+	return v
+}
+
+// resolve a value outside of requests
+func (b *KApi) resolve(t reflect.Type) (reflect.Value, error) {
+	return b.resolveIn(t, resolving{})
+}
+
+// resolving the types being made by providers, from the outermost one
+type resolving struct {
+	types  []reflect.Type
+	locked bool // singletonLock is held by a singleton of the chain
+}
+
+// push add t to the chain, an error is returned if t is already being made
+func (r resolving) push(t reflect.Type) (resolving, error) {
+	for i, rt := range r.types {
+		if rt == t {
+			path := make([]string, 0, len(r.types)-i+1)
+			for _, rt := range r.types[i:] {
+				path = append(path, rt.String())
+			}
+			return r, fmt.Errorf("provider of %v depends on itself: %s -> %v", t, strings.Join(path, " -> "), t)
+		}
+	}
+	r.types = append(r.types[:len(r.types):len(r.types)], t)
+	return r, nil
+}
+
+func (b *KApi) resolveIn(t reflect.Type, r resolving) (reflect.Value, error) {
+	if v := b.Injector.Value(t); v.IsValid() {
+		return v, nil
+	}
+	p, ok := b.providers[t]
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("value not found for type %v", t)
+	}
+	switch p.lifetime {
+	case LifetimeSingleton:
+		return b.singleton(p, r)
+	case LifetimeTransient:
+		r, err := r.push(t)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		v, cleanup, err := p.call(b, func(t reflect.Type) (reflect.Value, error) {
+			return b.resolveIn(t, r)
+		})
+		if cleanup != nil {
+			b.addCleanup(cleanup)
+		}
+		return v, err
+	}
+	return reflect.Value{}, fmt.Errorf("%v is provided per request, it can not be injected here", t)
+}
+
+// singleton make the value of a singleton provider once. singletons are made one at a time, so two of them
+// asking for each other from different goroutines can not deadlock, a cycle fails with an error instead
+func (b *KApi) singleton(p *provider, r resolving) (reflect.Value, error) {
+	p.lock.Lock()
+	v := p.value
+	p.lock.Unlock()
+	if v.IsValid() {
+		return v, nil
+	}
+	r, err := r.push(p.typ)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	if !r.locked {
+		b.singletonLock.Lock()
+		defer b.singletonLock.Unlock()
+		r.locked = true
+		// made by another goroutine while waiting
+		p.lock.Lock()
+		v = p.value
+		p.lock.Unlock()
+		if v.IsValid() {
+			return v, nil
+		}
+	}
+	v, cleanup, err := p.call(b, func(t reflect.Type) (reflect.Value, error) {
+		return b.resolveIn(t, r)
+	})
+	if err != nil {
+		return v, err
+	}
+	p.lock.Lock()
+	p.value = v
+	p.lock.Unlock()
+	if cleanup != nil {
+		b.addCleanup(cleanup)
+	}
+	return v, nil
+}
+
+// addCleanup add a cleanup func of singletons, they run on shutdown in reverse order
+func (b *KApi) addCleanup(f func()) {
+	b.cleanupLock.Lock()
+	defer b.cleanupLock.Unlock()
+	b.cleanups = append(b.cleanups, f)
+}
+
+// runCleanups run cleanup funcs of singletons
+func (b *KApi) runCleanups() {
+	b.cleanupLock.Lock()
+	cleanups := b.cleanups
+	b.cleanups = nil
+	b.cleanupLock.Unlock()
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		cleanups[i]()
+	}
+}
+
+// Apply set `inject` fields of a struct, singleton providers included
+//
+//	@param val pointer of struct
+//
+//	@return error
+func (b *KApi) Apply(val any) error {
+	return applyWith(val, b.resolve, b)
+}
+
+// Invoke call f with args from the mapped values, singleton providers included
+//
+//	@param f
+//
+//	@return []reflect.Value
+//	@return error
+func (b *KApi) Invoke(f any) ([]reflect.Value, error) {
+	return invokeWith(f, b.resolve)
+}
+
+// requestScope the injector of a request. values mapped to it and values of request providers
+// live until the request ends, the parent is KApi
+type requestScope struct {
+	inject.Injector
+	kapi      *KApi
+	resolving resolving
+	cleanups  []func()
+	mapped    map[reflect.Type]bool // types mapped exactly, they win over providers
+}
+
+var _ inject.Injector = (*requestScope)(nil)
+
+func newRequestScope(k *KApi) *requestScope {
+	return &requestScope{Injector: inject.New(), kapi: k}
+}
+
+func (s *requestScope) Value(t reflect.Type) reflect.Value {
+	v, _ := s.resolve(t)
+	return v
+}
+
+func (s *requestScope) Map(values ...any) inject.TypeMapper {
+	for _, v := range values {
+		s.mark(reflect.TypeOf(v))
+	}
+	s.Injector.Map(values...)
+	return s
+}
+
+func (s *requestScope) MapTo(val any, ifacePtr any) inject.TypeMapper {
+	s.mark(inject.InterfaceOf(ifacePtr))
+	s.Injector.MapTo(val, ifacePtr)
+	return s
+}
+
+func (s *requestScope) Set(t reflect.Type, val reflect.Value) inject.TypeMapper {
+	s.mark(t)
+	s.Injector.Set(t, val)
+	return s
+}
+
+func (s *requestScope) mark(t reflect.Type) {
+	if s.mapped == nil {
+		s.mapped = make(map[reflect.Type]bool)
+	}
+	s.mapped[t] = true
+}
+
+func (s *requestScope) resolve(t reflect.Type) (reflect.Value, error) {
+	p, ok := s.kapi.providers[t]
+	// a mapped value implementing interface t does not hide the provider of t, e.g. *Context is a context.Context
+	if !ok || p.lifetime == LifetimeSingleton || s.mapped[t] {
+		if v := s.Injector.Value(t); v.IsValid() {
+			return v, nil
+		}
+		return s.kapi.resolve(t)
+	}
+	outer := s.resolving
+	r, err := outer.push(t)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	s.resolving = r
+	v, cleanup, err := p.call(s.kapi, s.resolve)
+	s.resolving = outer
+	if err != nil {
+		return v, err
+	}
+	if cleanup != nil {
+		s.cleanups = append(s.cleanups, cleanup)
+	}
+	if p.lifetime == LifetimeRequest {
+		s.Set(t, v)
+	}
+	return v, nil
+}
+
+func (s *requestScope) Apply(val any) error {
+	return applyWith(val, s.resolve, s.kapi)
+}
+
+func (s *requestScope) Invoke(f any) ([]reflect.Value, error) {
+	return invokeWith(f, s.resolve)
+}
+
+func (s *requestScope) Provide(val any) error {
+	return provideWith(val, s.resolve)
+}
+
+// close run cleanup funcs in reverse order
+func (s *requestScope) close() {
+	for i := len(s.cleanups) - 1; i >= 0; i-- {
+		s.cleanups[i]()
+	}
+	s.cleanups = nil
+}
+
+// invokeWith same as inject's Invoke, args are resolved by value
+func invokeWith(f any, value func(reflect.Type) (reflect.Value, error)) ([]reflect.Value, error) {
+	t := reflect.TypeOf(f)
+	if fi, ok := f.(inject.FastInvoker); ok {
+		in := make([]any, t.NumIn())
+		for i := range in {
+			v, err := value(t.In(i))
+			if err != nil {
+				return nil, err
+			}
+			in[i] = v.Interface()
+		}
+		return fi.Invoke(in)
+	}
+	in := make([]reflect.Value, t.NumIn())
+	for i := range in {
+		v, err := value(t.In(i))
+		if err != nil {
+			return nil, err
+		}
+		in[i] = v
+	}
+	return reflect.ValueOf(f).Call(in), nil
+}
+
+// applyWith same as inject's Apply, fields are resolved by value. `inject:"config:<path>"` fields are populated from config,
+// other fields are left alone if value is nil
+func applyWith(val any, value func(reflect.Type) (reflect.Value, error), k *KApi) error {
+	v := reflect.ValueOf(val)
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	t := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		tag, ok := t.Field(i).Tag.Lookup("inject")
+		if !ok || !f.CanSet() {
+			continue
+		}
+		if path, optional, ok := parseConfigTag(tag); ok {
+			fv, err := k.configValue(path, f.Type(), optional)
+			if err != nil {
+				return &ConfigError{Path: path, Field: t.Name() + "." + t.Field(i).Name, Err: err}
+			}
+			f.Set(fv)
+			continue
+		}
+		if value == nil {
+			continue
+		}
+		fv, err := value(f.Type())
+		if err != nil {
+			return err
+		}
+		f.Set(fv)
+	}
+	return nil
+}
+
+// provideWith same as inject's Provide, the value is resolved by value
+func provideWith(val any, value func(reflect.Type) (reflect.Value, error)) error {
+	if val == nil {
+		return fmt.Errorf("val cannot be nil")
+	}
+	v := reflect.ValueOf(val)
+	t0 := v.Type()
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v1, err := value(v.Type()); err == nil {
+		v.Set(v1)
+		return nil
+	}
+	v2, err := value(t0)
+	if err != nil {
+		return err
+	}
+	v.Set(v2.Elem())
+	return nil
+}
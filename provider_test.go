@@ -0,0 +1,106 @@
+package kapi
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type (
+	depA struct{ b *depB }
+	depB struct{ a *depA }
+	depC struct{}
+)
+
+var (
+	depAType = reflect.TypeOf((*depA)(nil))
+	depBType = reflect.TypeOf((*depB)(nil))
+)
+
+func TestProviderCycle(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Lifetime
+	}{
+		{"singletons", LifetimeSingleton, LifetimeSingleton},
+		{"transients", LifetimeTransient, LifetimeTransient},
+		{"singleton and transient", LifetimeSingleton, LifetimeTransient},
+		{"transient and singleton", LifetimeTransient, LifetimeSingleton},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newTestKApi(t)
+			_ = k.ProvideWith(func(b *depB) *depA { return &depA{b: b} }, tt.a)
+			_ = k.ProvideWith(func(a *depA) *depB { return &depB{a: a} }, tt.b)
+			errc := make(chan error, 1)
+			go func() {
+				_, err := k.resolve(depAType)
+				errc <- err
+			}()
+			select {
+			case err := <-errc:
+				want := "depends on itself: *kapi.depA -> *kapi.depB -> *kapi.depA"
+				if err == nil || !strings.Contains(err.Error(), want) {
+					t.Errorf("resolve() err = %v, want %q", err, want)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("resolve() deadlocked")
+			}
+		})
+	}
+}
+
+func TestRequestProviderCycle(t *testing.T) {
+	k := newTestKApi(t)
+	_ = k.Provide(func(b *depB) *depA { return &depA{b: b} })
+	_ = k.ProvideTransient(func(a *depA) *depB { return &depB{a: a} })
+	s := newRequestScope(k)
+	_, err := s.resolve(depAType)
+	want := "depends on itself: *kapi.depA -> *kapi.depB -> *kapi.depA"
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("resolve() err = %v, want %q", err, want)
+	}
+	if len(s.resolving.types) != 0 {
+		t.Errorf("resolving = %v after resolve", s.resolving.types)
+	}
+}
+
+func TestSingletonConcurrent(t *testing.T) {
+	k := newTestKApi(t)
+	var made atomic.Int32
+	_ = k.ProvideSingleton(func(b *depB) *depA {
+		made.Add(1)
+		time.Sleep(10 * time.Millisecond)
+		return &depA{b: b}
+	})
+	_ = k.ProvideSingleton(func(c *depC) *depB {
+		time.Sleep(10 * time.Millisecond)
+		return &depB{}
+	})
+	_ = k.ProvideSingleton(func() *depC { return &depC{} })
+	var wg sync.WaitGroup
+	values := make([]reflect.Value, 8)
+	for i := range values {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// half of them start from the dependency
+			if i%2 == 1 {
+				k.Value(depBType)
+			}
+			values[i] = k.Value(depAType)
+		}(i)
+	}
+	wg.Wait()
+	if made.Load() != 1 {
+		t.Errorf("singleton made %d times", made.Load())
+	}
+	for _, v := range values {
+		if !v.IsValid() || v.Pointer() != values[0].Pointer() {
+			t.Fatalf("got different values: %v", values)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package kapi
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/linxlib/kapi/internal"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateResult the result of taking a token
+type RateResult struct {
+	Allowed    bool
+	Remaining  int           // tokens left in the bucket
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until a token is available, if not allowed
+}
+
+// RateStore holds the token buckets of @RATE, the default stores them in memory.
+// implement it on redis or a database to share limits between instances
+type RateStore interface {
+	// Take take a token from the bucket of key, which holds limit tokens and refills them in window
+	Take(ctx context.Context, key string, limit int, window time.Duration) (RateResult, error)
+}
+
+// MemoryRateStore token buckets in memory, idle buckets are dropped once they are full again.
+// beyond MaxBuckets the least recently used buckets are dropped, so made up subjects can't grow it without bound
+type MemoryRateStore struct {
+	// MaxBuckets most buckets kept, default 100000
+	MaxBuckets int
+
+	lock      sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time // when the bucket is full if no token is taken
+}
+
+// NewMemoryRateStore create a store of token buckets in memory
+//
+//	@return *MemoryRateStore
+func NewMemoryRateStore() *MemoryRateStore {
+	return &MemoryRateStore{MaxBuckets: 100000, buckets: make(map[string]*tokenBucket), lastSweep: time.Now()}
+}
+
+func (s *MemoryRateStore) Take(_ context.Context, key string, limit int, window time.Duration) (RateResult, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	if now.Sub(s.lastSweep) > time.Minute {
+		s.sweep(now)
+	}
+	perSecond := float64(limit) / window.Seconds()
+	b, ok := s.buckets[key]
+	if !ok {
+		if s.MaxBuckets > 0 && len(s.buckets) >= s.MaxBuckets {
+			s.evict(now)
+		}
+		b = &tokenBucket{tokens: float64(limit), last: now}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit), b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+	r := RateResult{}
+	if b.tokens >= 1 {
+		b.tokens--
+		r.Allowed = true
+	} else {
+		r.RetryAfter = time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+	}
+	r.Remaining = int(b.tokens)
+	r.Reset = time.Duration((float64(limit) - b.tokens) / perSecond * float64(time.Second))
+	b.full = now.Add(r.Reset)
+	return r, nil
+}
+
+// sweep drop the buckets which are full again
+func (s *MemoryRateStore) sweep(now time.Time) {
+	for k, b := range s.buckets {
+		if now.After(b.full) {
+			delete(s.buckets, k)
+		}
+	}
+	s.lastSweep = now
+}
+
+// evict make room for new buckets, the least recently used tenth is dropped if sweeping is not enough
+func (s *MemoryRateStore) evict(now time.Time) {
+	s.sweep(now)
+	if len(s.buckets) < s.MaxBuckets {
+		return
+	}
+	keys := make([]string, 0, len(s.buckets))
+	for k := range s.buckets {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return s.buckets[keys[i]].last.Before(s.buckets[keys[j]].last)
+	})
+	for _, k := range keys[:len(keys)-s.MaxBuckets*9/10] {
+		delete(s.buckets, k)
+	}
+}
+
+// SetRateStore set where the token buckets of @RATE are stored
+//
+//	@param s
+func (b *KApi) SetRateStore(s RateStore) {
+	b.rateStore = s
+}
+
+// rateLimit a parsed @RATE, e.g. 100/m by=ip or 10/s by=header:X-API-Key
+type rateLimit struct {
+	limit  int
+	window time.Duration
+	header string // requests are limited by this header, by ip if empty
+}
+
+var rateUnits = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+}
+
+// parseRate parse @RATE. the window is s, m, h, d or a duration like 10s
+//
+//	@param s
+//
+//	@return *rateLimit nil if s is empty
+//	@return error
+func parseRate(s string) (*rateLimit, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	n, w, ok := strings.Cut(fields[0], "/")
+	limit, err := strconv.Atoi(n)
+	if !ok || err != nil || limit <= 0 {
+		return nil, fmt.Errorf("@RATE %s: want <limit>/<window> like 100/m", s)
+	}
+	r := &rateLimit{limit: limit}
+	if d, ok := rateUnits[w]; ok {
+		r.window = d
+	} else if r.window, err = time.ParseDuration(w); err != nil || r.window <= 0 {
+		return nil, fmt.Errorf("@RATE %s: window should be s, m, h, d or a duration like 10s", s)
+	}
+	for _, f := range fields[1:] {
+		by, ok := strings.CutPrefix(f, "by=")
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("@RATE %s: unknown option %s", s, f)
+		case by == "ip":
+			r.header = ""
+		case strings.HasPrefix(by, "header:") && len(by) > len("header:"):
+			r.header = http.CanonicalHeaderKey(strings.TrimPrefix(by, "header:"))
+		default:
+			return nil, fmt.Errorf("@RATE %s: by should be ip or header:<name>", s)
+		}
+	}
+	return r, nil
+}
+
+// by ip or header:<name>, as documented in the spec
+func (r *rateLimit) by() string {
+	if r.header == "" {
+		return "ip"
+	}
+	return "header:" + r.header
+}
+
+// subject who is limited. header values are hashed, so keys are not kept in the store.
+// requests without the header are limited by ip. the ip is taken from X-Forwarded-For only if the
+// request comes from server.trustedProxies. header values are not checked, a client may send a new one
+// to get a full bucket, so limit by a header only if it's verified before kapi, e.g. by a gateway
+func (r *rateLimit) subject(c *Context) string {
+	if r.header != "" {
+		if v := c.GetHeader(r.header); v != "" {
+			sum := sha256.Sum256([]byte(v))
+			return "h:" + hex.EncodeToString(sum[:16])
+		}
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// allow take a token of the route and set the RateLimit headers. a failing store lets requests through
+//
+//	@param c
+//	@param route method and path of the route, buckets are per route
+//
+//	@return bool false if c has been responded with 429
+func (r *rateLimit) allow(c *Context, route string) bool {
+	store := c.kapi.rateStore
+	res, err := store.Take(c.Request.Context(), route+"|"+r.subject(c), r.limit, r.window)
+	if err != nil {
+		internal.Errorf("rate limit %s: %s", route, err)
+		return true
+	}
+	c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d", r.limit, int(math.Ceil(r.window.Seconds()))))
+	c.Header("RateLimit-Limit", strconv.Itoa(r.limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+	if res.Allowed {
+		return true
+	}
+	c.Header("Retry-After", strconv.Itoa(max(ceilSeconds(res.RetryAfter), 1)))
+	c.Respond(c.OnStatus(http.StatusTooManyRequests, 0, http.StatusText(http.StatusTooManyRequests)))
+	c.Abort()
+	return false
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,142 @@
+package kapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in      string
+		limit   int
+		window  time.Duration
+		by      string
+		wantErr bool
+	}{
+		{in: "100/m", limit: 100, window: time.Minute, by: "ip"},
+		{in: "10/s by=header:x-api-key", limit: 10, window: time.Second, by: "header:X-Api-Key"},
+		{in: "5/10s by=ip", limit: 5, window: 10 * time.Second, by: "ip"},
+		{in: "0/m", wantErr: true},
+		{in: "10", wantErr: true},
+		{in: "10/w", wantErr: true},
+		{in: "10/m by=header:", wantErr: true},
+		{in: "10/m per=ip", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			r, err := parseRate(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRate() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if r.limit != tt.limit || r.window != tt.window || r.by() != tt.by {
+				t.Errorf("parseRate() = %d/%v by=%s, want %d/%v by=%s", r.limit, r.window, r.by(), tt.limit, tt.window, tt.by)
+			}
+		})
+	}
+}
+
+func TestRateLimitSubject(t *testing.T) {
+	tests := []struct {
+		name    string
+		rate    string
+		proxies []string
+		headers []map[string]string // of each request, all from 192.0.2.1
+		status  []int
+	}{
+		{
+			name:    "by ip",
+			rate:    "2/m",
+			headers: []map[string]string{nil, nil, nil},
+			status:  []int{200, 200, 429},
+		},
+		{
+			name: "spoofed forwarded for",
+			rate: "2/m",
+			headers: []map[string]string{
+				{"X-Forwarded-For": "10.0.0.1"},
+				{"X-Forwarded-For": "10.0.0.2"},
+				{"X-Forwarded-For": "10.0.0.3"},
+			},
+			status: []int{200, 200, 429},
+		},
+		{
+			name:    "trusted proxy",
+			rate:    "1/m",
+			proxies: []string{"192.0.2.0/24"},
+			headers: []map[string]string{
+				{"X-Forwarded-For": "10.0.0.1"},
+				{"X-Forwarded-For": "10.0.0.2"},
+				{"X-Forwarded-For": "10.0.0.1"},
+			},
+			status: []int{200, 200, 429},
+		},
+		{
+			name: "by header",
+			rate: "1/m by=header:X-API-Key",
+			headers: []map[string]string{
+				{"X-API-Key": "a"},
+				{"X-API-Key": "b"},
+				{"X-API-Key": "a"},
+				nil,
+				nil,
+			},
+			status: []int{200, 200, 429, 200, 429},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newTestKApi(t, WithServer(func(s *ServerOption) {
+				s.TrustedProxies = tt.proxies
+			}))
+			err := k.registerMethodToRouter(RouteItem{Method: "GET", RouterPath: "/limited", Rate: tt.rate}, "Test.Get", nil,
+				func(c *Context) (any, error) {
+					return "ok", nil
+				})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, h := range tt.headers {
+				req := httptest.NewRequest(http.MethodGet, "/limited", nil)
+				req.RemoteAddr = "192.0.2.1:1234"
+				for k, v := range h {
+					req.Header.Set(k, v)
+				}
+				w := serve(k, req)
+				if w.Code != tt.status[i] {
+					t.Errorf("request %d status = %d, want %d", i, w.Code, tt.status[i])
+				}
+				if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+					t.Errorf("request %d has no Retry-After", i)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryRateStoreMaxBuckets(t *testing.T) {
+	s := NewMemoryRateStore()
+	s.MaxBuckets = 10
+	ctx := context.Background()
+	if r, _ := s.Take(ctx, "kept", 1, time.Hour); !r.Allowed {
+		t.Fatal("first take is not allowed")
+	}
+	for i := 0; i < 100; i++ {
+		_, _ = s.Take(ctx, fmt.Sprint("made up ", i), 1, time.Hour)
+		if i%5 == 0 {
+			// recently used buckets are kept
+			if r, _ := s.Take(ctx, "kept", 1, time.Hour); r.Allowed {
+				t.Fatalf("bucket of kept is dropped after %d subjects", i+1)
+			}
+		}
+	}
+	if n := len(s.buckets); n > s.MaxBuckets {
+		t.Errorf("%d buckets, want at most %d", n, s.MaxBuckets)
+	}
+}
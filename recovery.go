@@ -0,0 +1,123 @@
+package kapi
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/linxlib/kapi/internal"
+	"html/template"
+	"net"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strings"
+)
+
+// exitSignal the value Context.Exit panics with. it is recovered by kapi and never treated as a panic
+type exitSignal struct{}
+
+var errExit = exitSignal{}
+
+// isExit check if a recovered value is from Context.Exit
+func isExit(err any) bool {
+	return err == errExit || err == KAPIEXIT
+}
+
+// PanicError a recovered panic of a controller method
+type PanicError struct {
+	Value any    // the value passed to panic
+	Stack []byte // stack of the panicking goroutine
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// PanicReporter receives every recovered panic, e.g. report it to sentry
+type PanicReporter func(c *Context, err *PanicError)
+
+// AddPanicReporter add a reporter of panics
+//
+//	@param reporter
+func (b *KApi) AddPanicReporter(reporter PanicReporter) {
+	b.panicReporters = append(b.panicReporters, reporter)
+}
+
+// handlePanic report a panic and respond 500 through OnError if nothing written.
+// in debug mode the response is a stack page, html for browsers and json for others
+//
+//	@param c
+//	@param controller may be nil if the panic is not from a controller method
+//	@param err the recovered value
+func (b *KApi) handlePanic(c *Context, controller any, err any) {
+	pe := &PanicError{Value: err, Stack: debug.Stack()}
+	internal.Errorf("[Recovery] %s %s request id: %s %s\n%s", c.Request.Method, c.Request.URL.Path, c.RequestID(), pe, pe.Stack)
+	b.option.recoverErrorFunc(err)
+	b.httpMetrics.panics.Inc(b.routeOf(c.Context))
+	for _, reporter := range b.panicReporters {
+		reporter(c, pe)
+	}
+	if i, ok := controller.(OnPanic); ok {
+		i.OnPanic(c, err)
+	}
+	if isBrokenPipe(err) {
+		// connection is gone, nothing can be written
+		_ = c.Error(pe)
+		c.Abort()
+		return
+	}
+	if !c.Writer.Written() {
+		switch {
+		case b.option.Server.Debug && strings.Contains(c.GetHeader("Accept"), "text/html"):
+			c.Status(http.StatusInternalServerError)
+			c.Header("Content-Type", "text/html; charset=utf-8")
+			_ = stackPage.Execute(c.Writer, pe)
+		case b.option.Server.Debug:
+			c.Respond(c.OnErrorDetail(pe.Error(), gin.H{
+				"panic": fmt.Sprint(pe.Value),
+				"stack": strings.Split(string(pe.Stack), "\n"),
+			}))
+		default:
+			c.Respond(c.OnError(http.StatusText(http.StatusInternalServerError), errors.New(http.StatusText(http.StatusInternalServerError))))
+		}
+	}
+	c.Abort()
+}
+
+// isBrokenPipe check if the panic is caused by a closed connection
+func isBrokenPipe(err any) bool {
+	if ne, ok := err.(*net.OpError); ok {
+		var se *os.SyscallError
+		if errors.As(ne, &se) {
+			msg := strings.ToLower(se.Error())
+			return strings.Contains(msg, "broken pipe") || strings.Contains(msg, "connection reset by peer")
+		}
+	}
+	return false
+}
+
+// recovery recover panics of all gin handlers, includes the ones not registered by controllers
+func (b *KApi) recovery() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		defer func() {
+			if err := recover(); err != nil {
+				if isExit(err) {
+					context.Abort()
+					return
+				}
+				b.handlePanic(newContext(context, b), nil, err)
+			}
+		}()
+		context.Next()
+	}
+}
+
+var stackPage = template.Must(template.New("stack").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>500 Internal Server Error</title></head>
+<body style="font-family: monospace">
+<h2>{{.Error}}</h2>
+<pre style="background: #f6f8fa; padding: 12px">{{printf "%s" .Stack}}</pre>
+</body>
+</html>
+`))
@@ -0,0 +1,126 @@
+package kapi
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// panicController records the panics passed to OnPanic
+type panicController struct {
+	panics *[]any
+}
+
+func (p panicController) OnPanic(c *Context, err interface{}) {
+	*p.panics = append(*p.panics, err)
+}
+
+func TestExitIsNotPanic(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler any
+		status  int
+		panics  int // values seen by the recover func, OnPanic and reporters each
+	}{
+		{"Exit", func(c *Context) { c.JSON(http.StatusAccepted, "done"); c.Exit() }, http.StatusAccepted, 0},
+		{"NotFoundExit", func(c *Context) { c.NotFoundExit("gone") }, http.StatusNotFound, 0},
+		{"legacy KAPIEXIT", func(c *Context) { c.JSON(http.StatusAccepted, "done"); panic(KAPIEXIT) }, http.StatusAccepted, 0},
+		{"panic", func(c *Context) { panic("boom") }, http.StatusInternalServerError, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var recovered, reported, onPanic []any
+			k := newTestKApi(t, WithRecoverFunc(func(err interface{}) {
+				recovered = append(recovered, err)
+			}))
+			k.AddPanicReporter(func(c *Context, err *PanicError) {
+				if len(err.Stack) == 0 {
+					t.Error("panic reported without stack")
+				}
+				reported = append(reported, err.Value)
+			})
+			k.route(http.MethodGet, "/x", panicController{panics: &onPanic}, tt.handler)
+			w := serve(k, httptest.NewRequest(http.MethodGet, "/x", nil))
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			for name, got := range map[string][]any{"recover func": recovered, "reporter": reported, "OnPanic": onPanic} {
+				if len(got) != tt.panics {
+					t.Errorf("%s got %v, want %d panics", name, got, tt.panics)
+				} else if tt.panics > 0 && got[0] != "boom" {
+					t.Errorf("%s got %v, want boom", name, got[0])
+				}
+			}
+		})
+	}
+}
+
+func TestRecoveryOfGinHandlers(t *testing.T) {
+	var recovered []any
+	k := newTestKApi(t, WithRecoverFunc(func(err interface{}) {
+		recovered = append(recovered, err)
+	}))
+	k.engine.GET("/exit", func(c *gin.Context) {
+		c.String(http.StatusAccepted, "done")
+		panic(errExit)
+	})
+	k.engine.GET("/panic", func(c *gin.Context) {
+		panic("boom")
+	})
+	if w := serve(k, httptest.NewRequest(http.MethodGet, "/exit", nil)); w.Code != http.StatusAccepted {
+		t.Errorf("exit status = %d", w.Code)
+	}
+	if len(recovered) != 0 {
+		t.Fatalf("exit reached the recover func: %v", recovered)
+	}
+	if w := serve(k, httptest.NewRequest(http.MethodGet, "/panic", nil)); w.Code != http.StatusInternalServerError {
+		t.Errorf("panic status = %d", w.Code)
+	}
+	if len(recovered) != 1 || recovered[0] != "boom" {
+		t.Errorf("recovered %v, want boom", recovered)
+	}
+}
+
+func TestPanicResponse(t *testing.T) {
+	tests := []struct {
+		name        string
+		debug       bool
+		accept      string
+		contentType string
+		stack       bool // the body shows the panic and its stack
+	}{
+		{"debug html", true, "text/html,application/xhtml+xml", "text/html; charset=utf-8", true},
+		{"debug json", true, "application/json", "application/json", true},
+		{"html", false, "text/html,application/xhtml+xml", "application/json", false},
+		{"json", false, "", "application/json", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newTestKApi(t, WithServer(func(s *ServerOption) {
+				s.Debug = tt.debug
+			}))
+			k.route(http.MethodGet, "/x", nil, func(c *Context) {
+				panic("secret boom")
+			})
+			req := httptest.NewRequest(http.MethodGet, "/x", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := serve(k, req)
+			if w.Code != http.StatusInternalServerError {
+				t.Errorf("status = %d", w.Code)
+			}
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, tt.contentType) {
+				t.Errorf("Content-Type = %q, want %s", ct, tt.contentType)
+			}
+			body := w.Body.String()
+			for _, s := range []string{"secret boom", "goroutine", "recovery_test.go"} {
+				if strings.Contains(body, s) != tt.stack {
+					t.Errorf("body contains %q = %v, want %v: %s", s, !tt.stack, tt.stack, body)
+				}
+			}
+		})
+	}
+}
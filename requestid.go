@@ -0,0 +1,227 @@
+package kapi
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"strings"
+)
+
+const (
+	// HeaderRequestID the header of the request ID, accepted from clients and echoed in responses
+	HeaderRequestID = "X-Request-ID"
+	// HeaderTraceParent the W3C trace context header
+	HeaderTraceParent = "traceparent"
+)
+
+// requestIDKey key of *correlation in gin.Context and context.Context
+type requestIDKey struct{}
+
+const ginRequestIDKey = "kapi.requestID"
+
+// correlation the IDs of a request
+type correlation struct {
+	id       string // request ID
+	traceID  string // 32 hex of traceparent
+	parentID string // 16 hex of traceparent, empty if the request has none
+	flags    string // trace flags of traceparent
+}
+
+// traceParent a traceparent header of a new parent id in the same trace
+func (r *correlation) traceParent() string {
+	return "00-" + r.traceID + "-" + randomHex(8) + "-" + r.flags
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID a request ID from clients is at most 128 printable ASCII chars
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// parseTraceParent returns the trace id, parent id and flags of version 00 traceparent
+func parseTraceParent(s string) (traceID string, parentID string, flags string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return "", "", "", false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return "", "", "", false
+	}
+	for _, p := range parts[:4] {
+		if _, err := hex.DecodeString(p); err != nil || p != strings.ToLower(p) {
+			return "", "", "", false
+		}
+	}
+	if parts[1] == strings.Repeat("0", 32) || parts[2] == strings.Repeat("0", 16) {
+		return "", "", "", false
+	}
+	return parts[1], parts[2], parts[3], true
+}
+
+// requestIDHandler accept X-Request-ID and traceparent of the request or generate them, the request ID is
+// echoed in X-Request-ID of the response. a generated request ID is the trace id
+func (b *KApi) requestIDHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		r := &correlation{flags: "01"}
+		if traceID, parentID, flags, ok := parseTraceParent(c.GetHeader(HeaderTraceParent)); ok {
+			r.traceID, r.parentID, r.flags = traceID, parentID, flags
+		}
+		if id := c.GetHeader(HeaderRequestID); validRequestID(id) {
+			r.id = id
+		} else if r.traceID != "" {
+			r.id = r.traceID
+		} else {
+			r.id = randomHex(16)
+		}
+		if r.traceID == "" {
+			r.traceID = randomHex(16)
+			if len(r.id) == 32 {
+				if _, err := hex.DecodeString(r.id); err == nil && r.id == strings.ToLower(r.id) {
+					r.traceID = r.id
+				}
+			}
+		}
+		c.Set(ginRequestIDKey, r)
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestIDKey{}, r))
+		c.Header(HeaderRequestID, r.id)
+		c.Next()
+	}
+}
+
+// correlationOf the IDs of a request, nil outside of requests
+func correlationOf(c *gin.Context) *correlation {
+	if v, ok := c.Get(ginRequestIDKey); ok {
+		return v.(*correlation)
+	}
+	return nil
+}
+
+// requestID the request ID of c, the header of the request if the middleware is not used
+func requestID(c *gin.Context) string {
+	if r := correlationOf(c); r != nil {
+		return r.id
+	}
+	return c.GetHeader(HeaderRequestID)
+}
+
+// RequestID returns the ID of the request, accepted from X-Request-ID or generated
+//
+//	@return string
+func (c *Context) RequestID() string {
+	return requestID(c.Context)
+}
+
+// TraceID returns the trace id of traceparent, generated if the request has none
+//
+//	@return string
+func (c *Context) TraceID() string {
+	if r := correlationOf(c.Context); r != nil {
+		return r.traceID
+	}
+	return ""
+}
+
+// RequestIDFromContext returns the request ID in ctx, e.g. c.Request.Context()
+//
+//	@param ctx
+//
+//	@return string empty if ctx is not of a request
+func RequestIDFromContext(ctx context.Context) string {
+	if r, ok := ctx.Value(requestIDKey{}).(*correlation); ok {
+		return r.id
+	}
+	return ""
+}
+
+// forwardTransport set X-Request-ID and traceparent of outgoing requests
+type forwardTransport struct {
+	base http.RoundTripper
+	r    *correlation // used if the request context has none
+	span trace.Span   // same as r
+}
+
+func (t *forwardTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	r, ok := ctx.Value(requestIDKey{}).(*correlation)
+	if !ok {
+		r = t.r
+	}
+	if !trace.SpanContextFromContext(ctx).IsValid() && t.span != nil {
+		ctx = trace.ContextWithSpan(ctx, t.span)
+	}
+	// a client span of the current span, its id is the parent of the outgoing traceparent
+	var span trace.Span
+	if trace.SpanFromContext(ctx).IsRecording() {
+		ctx, span = StartSpan(ctx, "HTTP "+req.Method, trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("http.request.method", req.Method),
+				attribute.String("url.full", req.URL.Redacted()),
+			))
+	}
+	if r != nil || trace.SpanContextFromContext(ctx).IsValid() {
+		req = req.Clone(ctx)
+		if r != nil && req.Header.Get(HeaderRequestID) == "" {
+			req.Header.Set(HeaderRequestID, r.id)
+		}
+		if req.Header.Get(HeaderTraceParent) == "" {
+			if trace.SpanContextFromContext(ctx).IsValid() {
+				propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+			} else {
+				req.Header.Set(HeaderTraceParent, r.traceParent())
+			}
+		}
+	}
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if span == nil {
+		return base.RoundTrip(req)
+	}
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		endSpan(span, err)
+		return resp, err
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= 500 {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	span.End()
+	return resp, err
+}
+
+// HTTPClient returns a client forwarding the request ID and trace of the request context, so requests
+// should be made with http.NewRequestWithContext(c.Request.Context(), ...). calls are traced as client spans
+//
+//	@return *http.Client
+func (b *KApi) HTTPClient() *http.Client {
+	return &http.Client{Transport: &forwardTransport{}}
+}
+
+// HTTPClient returns a client forwarding the request ID and trace of this request. it can also be injected
+// as *http.Client
+//
+//	@return *http.Client
+func (c *Context) HTTPClient() *http.Client {
+	return &http.Client{Transport: &forwardTransport{r: correlationOf(c.Context), span: SpanFromContext(c.Request.Context())}}
+}
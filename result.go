@@ -1,5 +1,7 @@
 package kapi
 
+import "net/http"
+
 // IOnSuccess 200
 type IOnSuccess = func(msg string, data any) (statusCode int, result any)
 
@@ -9,6 +11,9 @@ type IOnFail = func(msg string, data any) (statusCode int, result any)
 // IOnData 200
 type IOnData = func(msg string, count int64, data any) (statusCode int, result any)
 
+// IOnPage 200, a page returned by controller method
+type IOnPage = func(msg string, page *Page) (statusCode int, result any)
+
 // IOnError 500
 type IOnError = func(msg string, err error) (statusCode int, result any)
 type IOnErrorDetail = func(msg string, err any) (statusCode int, result any)
@@ -22,29 +27,44 @@ type IOnNoPermission = func(msg string) (statusCode int, result any)
 // IOnNotFound 404
 type IOnNotFound = func(msg string) (statusCode int, result any)
 
+// IOnStatus any status, used by errors mapped with MapError
+type IOnStatus = func(status int, code int, msg string) (statusCode int, result any)
+
+// IOnValidationFail 400 request param failed to validate or unmarshal
+type IOnValidationFail = func(msg string, errs ValidationErrors) (statusCode int, result any)
+
 func (b *KApi) RewriteOnSuccess(builder IOnSuccess) {
-	b.MapTo(builder, (*IOnSuccess)(nil))
+	b.resultBuilder.OnSuccess = builder
 }
 func (b *KApi) RewriteOnFail(builder IOnFail) {
-	b.MapTo(builder, (*IOnFail)(nil))
+	b.resultBuilder.OnFail = builder
 }
 func (b *KApi) RewriteOnErrorDetail(builder IOnErrorDetail) {
-	b.MapTo(builder, (*IOnErrorDetail)(nil))
+	b.resultBuilder.OnErrorDetail = builder
 }
 func (b *KApi) RewriteOnError(builder IOnError) {
-	b.MapTo(builder, (*IOnError)(nil))
+	b.resultBuilder.OnError = builder
 }
 func (b *KApi) RewriteOnUnAuthed(builder IOnUnAuthed) {
-	b.MapTo(builder, (*IOnUnAuthed)(nil))
+	b.resultBuilder.OnUnAuthed = builder
 }
 func (b *KApi) RewriteOnData(builder IOnData) {
-	b.MapTo(builder, (*IOnData)(nil))
+	b.resultBuilder.OnData = builder
+}
+func (b *KApi) RewriteOnPage(builder IOnPage) {
+	b.resultBuilder.OnPage = builder
 }
 func (b *KApi) RewriteOnNoPermission(builder IOnNoPermission) {
-	b.MapTo(builder, (*IOnNoPermission)(nil))
+	b.resultBuilder.OnNoPermission = builder
 }
 func (b *KApi) RewriteOnNotFound(builder IOnNotFound) {
-	b.MapTo(builder, (*IOnNotFound)(nil))
+	b.resultBuilder.OnNotFound = builder
+}
+func (b *KApi) RewriteOnValidationFail(builder IOnValidationFail) {
+	b.resultBuilder.OnValidationFail = builder
+}
+func (b *KApi) RewriteOnStatus(builder IOnStatus) {
+	b.resultBuilder.OnStatus = builder
 }
 
 type DefaultResultBuilder struct {
@@ -53,9 +73,14 @@ type DefaultResultBuilder struct {
 	OnNotFound     IOnNotFound
 	OnNoPermission IOnNoPermission
 	OnData         IOnData
+	OnPage         IOnPage
 	OnError        IOnError
 	OnUnAuthed     IOnUnAuthed
 	OnErrorDetail  IOnErrorDetail
+	// OnValidationFail data is the ValidationErrors
+	OnValidationFail IOnValidationFail
+	// OnStatus code is the business code of ErrorMapping, -1 if not set
+	OnStatus IOnStatus
 }
 
 func NewDefaultBuilder() *DefaultResultBuilder {
@@ -82,6 +107,18 @@ func NewDefaultBuilder() *DefaultResultBuilder {
 				Data:  data,
 			}
 		},
+		OnPage: func(msg string, page *Page) (statusCode int, result any) {
+			body := messageBody{
+				Code:  0,
+				Msg:   msg,
+				Data:  page.Items,
+				Links: &page.Links,
+			}
+			if page.Total > 0 {
+				body.Count = page.Total
+			}
+			return 200, body
+		},
 		OnError: func(msg string, err error) (statusCode int, result any) {
 			return 500, messageBody{
 				Code: -1,
@@ -114,6 +151,22 @@ func NewDefaultBuilder() *DefaultResultBuilder {
 				Data: err,
 			}
 		},
+		OnValidationFail: func(msg string, errs ValidationErrors) (statusCode int, result any) {
+			return 400, messageBody{
+				Code: -1,
+				Msg:  msg,
+				Data: errs,
+			}
+		},
+		OnStatus: func(status int, code int, msg string) (statusCode int, result any) {
+			if code == 0 {
+				code = -1
+			}
+			return status, messageBody{
+				Code: code,
+				Msg:  msg,
+			}
+		},
 	}
 }
 
@@ -123,6 +176,39 @@ type messageBody struct {
 	Msg   string      `json:"msg"`
 	Count int64       `json:"count,omitempty"`
 	Data  interface{} `json:"data"`
+	Links *PageLinks  `json:"links,omitempty"`
+	// RequestID set on error responses if server.requestIdInBody is true
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// Respond writes obj with the codec negotiated by Accept header, 406 if Accept can't be satisfied.
+// *Problem is always written as application/problem+json
+//
+//	@param statusCode
+//	@param obj
+func (c *Context) Respond(statusCode int, obj any) {
+	codec, ok := c.kapi.negotiate(c.GetHeader("Accept"), obj)
+	if !ok && statusCode < 400 {
+		// failures are still written with the default codec
+		statusCode, obj = c.OnStatus(http.StatusNotAcceptable, 0, http.StatusText(http.StatusNotAcceptable))
+		codec = c.kapi.defaultCodec(obj)
+	}
+	withID := statusCode >= 400 && c.kapi.option.Server.RequestIDInBody
+	if body, ok := obj.(messageBody); ok && withID {
+		body.RequestID = c.RequestID()
+		obj = body
+	}
+	if p, ok := obj.(*Problem); ok {
+		if p.Instance == "" {
+			p.Instance = c.Request.URL.RequestURI()
+		}
+		if withID {
+			p.With("requestId", c.RequestID())
+		}
+		c.Render(statusCode, problemRender{problem: p})
+		return
+	}
+	c.Render(statusCode, codec.Render(obj))
 }
 
 // WriteJSON 写入json对象
@@ -131,38 +217,45 @@ func (c *Context) WriteJSON(obj interface{}) {
 }
 
 func (c *Context) writeMessage(msg string) {
-	c.PureJSON(c.OnSuccess(msg, nil))
+	c.Respond(c.OnSuccess(msg, nil))
 }
 func (c *Context) writeError(err error) {
-	c.PureJSON(c.OnError(err.Error(), err))
+	c.Respond(c.OnError(err.Error(), err))
 }
 func (c *Context) writeErrorDetail(err interface{}) {
-	c.PureJSON(c.OnErrorDetail("", err))
+	c.Respond(c.OnErrorDetail("", err))
 }
 func (c *Context) writeFailMsg(msg string) {
-	c.PureJSON(c.OnFail(msg, nil))
+	c.Respond(c.OnFail(msg, nil))
 }
 
 func (c *Context) writeList(count int64, list any) {
-	c.PureJSON(c.OnData("", count, list))
+	c.Respond(c.OnData("", count, list))
 }
 func (c *Context) writeNoPermissionMsg(msg string) {
-	c.PureJSON(c.OnNoPermission(msg))
+	c.Respond(c.OnNoPermission(msg))
 }
 func (c *Context) writeNotFoundMsg(msg string) {
-	c.PureJSON(c.OnNotFound(msg))
+	c.Respond(c.OnNotFound(msg))
 }
 func (c *Context) writeUnAuthedMsg(msg string) {
-	c.PureJSON(c.OnUnAuthed(msg))
+	c.Respond(c.OnUnAuthed(msg))
 }
 func (c *Context) writeMsgAndData(msg string, data any) {
-	c.PureJSON(c.OnData(msg, 0, data))
+	c.Respond(c.OnData(msg, 0, data))
 }
 
+// KAPIEXIT
+//
+// Deprecated: Exit does not panic with it anymore, it is still treated as an exit when recovered
 var KAPIEXIT = "kapiexit"
 
+// Exit abort the context and stop the controller method. the panic used to unwind is recovered
+// by kapi and never reaches recover func, OnPanic or panic reporters.
+// write a response and return instead if the method is in a hot path
 func (c *Context) Exit() {
-	panic(KAPIEXIT)
+	c.Abort()
+	panic(errExit)
 }
 
 func (c *Context) ListExit(count int64, list any) {
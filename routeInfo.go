@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/gob"
 	"github.com/linxlib/kapi/internal"
+	"github.com/linxlib/kapi/internal/comment_parser"
 	"github.com/linxlib/kapi/internal/openapi"
 	"sync"
 	"time"
@@ -17,6 +18,8 @@ type RouteItem struct {
 	Summary     string
 	Description string
 	Method      string //HTTP METHOD
+	Rate        string //@RATE of method, e.g. 100/m by=ip
+	Cache       string //@CACHE of method, e.g. 30s vary=Authorization
 }
 
 type genInfo struct {
@@ -46,13 +49,17 @@ func init() {
 }
 
 // AddFunc add one method to method comments
-func (ri *RouteInfo) AddFunc(handlerFuncName, routerPath string, method string) {
+func (ri *RouteInfo) AddFunc(handlerFuncName, routerPath string, method string, comment *comment_parser.Comment) {
 	ri.mu.Lock()
 	defer ri.mu.Unlock()
 	ri.genInfo.Routes = append(ri.genInfo.Routes, RouteItem{
-		Key:        handlerFuncName,
-		RouterPath: routerPath,
-		Method:     method,
+		Key:         handlerFuncName,
+		RouterPath:  routerPath,
+		Method:      method,
+		Summary:     comment.Summary,
+		Description: comment.GetDescription(","),
+		Rate:        comment.Rate,
+		Cache:       comment.Cache,
 	})
 }
 func (ri *RouteInfo) GetGenInfo() *genInfo {
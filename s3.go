@@ -0,0 +1,328 @@
+package kapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Storage stores files in a bucket of an S3 compatible service (aws s3, minio...).
+// requests are signed with AWS signature v4 and use path style urls: Endpoint/Bucket/key
+type S3Storage struct {
+	Endpoint  string // e.g. https://s3.us-east-1.amazonaws.com or http://127.0.0.1:9000
+	Region    string // default us-east-1
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Client    *http.Client // default http.DefaultClient
+	// PartSize bytes of a part of multipart uploads, default and at least 5MB. a body of unknown size is
+	// buffered up to PartSize, it's uploaded with one PUT if it fits, otherwise part by part
+	PartSize int64
+}
+
+const (
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+	s3MinPartSize     = 5 << 20
+	s3MaxParts        = 10000
+)
+
+func (s *S3Storage) client() *http.Client {
+	if s.Client != nil {
+		return s.Client
+	}
+	return http.DefaultClient
+}
+
+func (s *S3Storage) partSize() int64 {
+	return max(s.PartSize, s3MinPartSize)
+}
+
+func (s *S3Storage) newRequest(ctx context.Context, method string, key string, query url.Values, body io.Reader) (*http.Request, error) {
+	k, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(strings.TrimRight(s.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	base := strings.TrimRight(u.Path, "/")
+	u.Path = base + "/" + s.Bucket + "/" + k
+	u.RawPath = s3Escape(base) + "/" + s3Escape(s.Bucket) + "/" + s3Escape(k)
+	u.RawQuery = s3Query(query)
+	return http.NewRequestWithContext(ctx, method, u.String(), body)
+}
+
+// s3Escape uri encode every byte except unreserved characters and '/', as signature v4 requires
+func s3Escape(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+// s3Query the canonical query string of signature v4: sorted by name, '/' is encoded as well
+func s3Query(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	escape := func(s string) string {
+		return strings.ReplaceAll(s3Escape(s), "/", "%2F")
+	}
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, escape(k)+"="+escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// sign add the signature v4 Authorization header
+//
+//	@param req
+//	@param payloadHash hex sha256 of the body or UNSIGNED-PAYLOAD
+//	@param now
+func (s *S3Storage) sign(req *http.Request, payloadHash string, now time.Time) {
+	region := s.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3Query(req.URL.Query()),
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func (s *S3Storage) do(req *http.Request, key string) (*http.Response, error) {
+	s.sign(req, s3UnsignedPayload, time.Now())
+	resp, err := s.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("s3: %s: %w", key, fs.ErrNotExist)
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return nil, fmt.Errorf("s3: %s %s: %s %s", req.Method, key, resp.Status, msg)
+}
+
+// Put upload with a PUT request. S3 requires Content-Length, so a body of unknown size is buffered up to
+// PartSize, and uploaded part by part if it's larger
+func (s *S3Storage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if size >= 0 {
+		return s.putObject(ctx, key, r, size, contentType)
+	}
+	buf := make([]byte, s.partSize())
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return s.putObject(ctx, key, bytes.NewReader(buf[:n]), int64(n), contentType)
+	}
+	if err != nil {
+		return err
+	}
+	return s.putMultipart(ctx, key, r, buf, contentType)
+}
+
+func (s *S3Storage) putObject(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	req, err := s.newRequest(ctx, http.MethodPut, key, nil, io.NopCloser(r))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(req, key)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+type s3Part struct {
+	PartNumber int
+	ETag       string
+}
+
+// putMultipart upload r part by part, buf is the full first part. the upload is aborted if any part fails
+func (s *S3Storage) putMultipart(ctx context.Context, key string, r io.Reader, buf []byte, contentType string) (err error) {
+	var created struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := s.doXML(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil, contentType, &created); err != nil {
+		return err
+	}
+	upload := url.Values{"uploadId": {created.UploadID}}
+	defer func() {
+		if err != nil {
+			// parts of an unfinished upload are kept and billed until aborted
+			req, rerr := s.newRequest(context.WithoutCancel(ctx), http.MethodDelete, key, upload, nil)
+			if rerr == nil {
+				if resp, rerr := s.do(req, key); rerr == nil {
+					_ = resp.Body.Close()
+				}
+			}
+		}
+	}()
+	var parts []s3Part
+	n := len(buf)
+	for number := 1; ; number++ {
+		if number > s3MaxParts {
+			return fmt.Errorf("s3: %s: more than %d parts, use a larger PartSize", key, s3MaxParts)
+		}
+		etag, err := s.uploadPart(ctx, key, created.UploadID, number, buf[:n])
+		if err != nil {
+			return err
+		}
+		parts = append(parts, s3Part{PartNumber: number, ETag: etag})
+		if n < len(buf) {
+			break
+		}
+		n, err = io.ReadFull(r, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+	}
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	var completed struct {
+		XMLName xml.Name
+		Message string `xml:"Message"`
+	}
+	if err := s.doXML(ctx, http.MethodPost, key, upload, body, "application/xml", &completed); err != nil {
+		return err
+	}
+	// the error of completing may come with 200
+	if completed.XMLName.Local == "Error" {
+		return fmt.Errorf("s3: complete %s: %s", key, completed.Message)
+	}
+	return nil
+}
+
+func (s *S3Storage) uploadPart(ctx context.Context, key string, uploadID string, number int, data []byte) (string, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+	req, err := s.newRequest(ctx, http.MethodPut, key, query, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	resp, err := s.do(req, key)
+	if err != nil {
+		return "", err
+	}
+	_ = resp.Body.Close()
+	return resp.Header.Get("ETag"), nil
+}
+
+// doXML send body and decode the xml response into v
+func (s *S3Storage) doXML(ctx context.Context, method string, key string, query url.Values, body []byte, contentType string, v any) error {
+	req, err := s.newRequest(ctx, method, key, query, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(req, key)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := xml.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("s3: %s %s: %w", method, key, err)
+	}
+	return nil
+}
+
+func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := s.newRequest(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req, key)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	req, err := s.newRequest(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req, key)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	return resp.Body.Close()
+}
@@ -0,0 +1,135 @@
+package kapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Event a server-sent event. return a <-chan Event or chan Event from a controller method to stream events:
+//
+//	// @SSE /progress
+//	func (e *Example) Progress(c *kapi.Context) <-chan kapi.Event {
+//		ch := make(chan kapi.Event)
+//		go func() {
+//			defer close(ch)
+//			select {
+//			case ch <- kapi.Event{Data: "hello"}:
+//			case <-c.Request.Context().Done(): //client disconnected
+//			}
+//		}()
+//		return ch
+//	}
+type Event struct {
+	ID    string        // id of the event, sent back by browser as Last-Event-ID when reconnecting
+	Event string        // event name, "message" if empty
+	Data  any           // string and []byte are written as is, others are json encoded
+	Retry time.Duration // reconnection time of browser
+}
+
+var (
+	// fieldReplacer removes line breaks, which would start a new field, and NUL, which makes browsers ignore an id
+	fieldReplacer = strings.NewReplacer("\r", "", "\n", "", "\x00", "")
+	lineReplacer  = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+)
+
+// Encode write the event in text/event-stream format
+//
+//	@param w
+//
+//	@return error
+func (e Event) Encode(w io.Writer) error {
+	var sb strings.Builder
+	if e.ID != "" {
+		sb.WriteString("id: " + fieldReplacer.Replace(e.ID) + "\n")
+	}
+	if e.Event != "" {
+		sb.WriteString("event: " + fieldReplacer.Replace(e.Event) + "\n")
+	}
+	if e.Retry > 0 {
+		sb.WriteString(fmt.Sprintf("retry: %d\n", e.Retry.Milliseconds()))
+	}
+	var data string
+	switch v := e.Data.(type) {
+	case nil:
+	case string:
+		data = v
+	case []byte:
+		data = string(v)
+	default:
+		bs, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		data = string(bs)
+	}
+	// a bare \r ends a line as well, it would start a new field
+	for _, line := range strings.Split(lineReplacer.Replace(data), "\n") {
+		sb.WriteString("data: " + line + "\n")
+	}
+	sb.WriteString("\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// LastEventID returns the id of the last event received by client before reconnecting
+//
+//	@return string
+func (c *Context) LastEventID() string {
+	if id := c.GetHeader("Last-Event-ID"); id != "" {
+		return id
+	}
+	return c.GetQueryString("lastEventId")
+}
+
+// serveEvents stream events of ch until it is closed, client disconnected or the server shuts down.
+// a comment line is written as heartbeat to keep proxies from closing the connection
+func (b *KApi) serveEvents(c *Context, ch <-chan Event) {
+	h := c.Writer.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	h.Set("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	heartbeat := time.Duration(b.option.Server.SSEHeartbeat) * time.Second
+	if heartbeat <= 0 {
+		heartbeat = 15 * time.Second
+	}
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+	done := c.Request.Context().Done()
+	for {
+		select {
+		case <-done:
+			return
+		case <-b.streamsDone:
+			// Shutdown doesn't cancel requests, it waits for them
+			return
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err := e.Encode(c.Writer); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case <-ticker.C:
+			if _, err := io.WriteString(c.Writer, ": ping\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
+
+// closeEventStreams end all event streams, so they don't hold up a graceful shutdown
+func (b *KApi) closeEventStreams() {
+	b.streamsOnce.Do(func() {
+		close(b.streamsDone)
+	})
+}
@@ -0,0 +1,122 @@
+package kapi
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventEncode(t *testing.T) {
+	tests := []struct {
+		name  string
+		event Event
+		want  string
+	}{
+		{"data", Event{Data: "hello"}, "data: hello\n\n"},
+		{"empty", Event{}, "data: \n\n"},
+		{"fields", Event{ID: "1", Event: "tick", Retry: 3 * time.Second, Data: "x"}, "id: 1\nevent: tick\nretry: 3000\ndata: x\n\n"},
+		{"json", Event{Data: map[string]int{"n": 1}}, "data: {\"n\":1}\n\n"},
+		{"bytes", Event{Data: []byte("raw")}, "data: raw\n\n"},
+		{"lines", Event{Data: "a\nb"}, "data: a\ndata: b\n\n"},
+		{"crlf lines", Event{Data: "a\r\nb\rc"}, "data: a\ndata: b\ndata: c\n\n"},
+		{"id injection", Event{ID: "1\r\nevent: evil", Data: "x"}, "id: 1event: evil\ndata: x\n\n"},
+		{"id cr injection", Event{ID: "1\revent: evil", Data: "x"}, "id: 1event: evil\ndata: x\n\n"},
+		{"event injection", Event{Event: "a\rdata: evil\x00", Data: "x"}, "event: adata: evil\ndata: x\n\n"},
+		{"data cr injection", Event{Data: "x\revent: evil"}, "data: x\ndata: event: evil\n\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sb strings.Builder
+			if err := tt.event.Encode(&sb); err != nil {
+				t.Fatal(err)
+			}
+			if sb.String() != tt.want {
+				t.Errorf("Encode() = %q, want %q", sb.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestServeEvents(t *testing.T) {
+	k := newTestKApi(t)
+	events := func() []Event {
+		return []Event{{ID: "1", Data: "a"}, {ID: "2", Data: "b"}}
+	}
+	k.route(http.MethodGet, "/recv", nil, func(c *Context) <-chan Event {
+		ch := make(chan Event, 2)
+		for _, e := range events() {
+			ch <- e
+		}
+		close(ch)
+		return ch
+	})
+	k.route(http.MethodGet, "/bidi", nil, func(c *Context) chan Event {
+		ch := make(chan Event, 2)
+		for _, e := range events() {
+			ch <- e
+		}
+		close(ch)
+		return ch
+	})
+	for _, path := range []string{"/recv", "/bidi"} {
+		t.Run(path, func(t *testing.T) {
+			w := serve(k, httptest.NewRequest(http.MethodGet, path, nil))
+			if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+				t.Fatalf("Content-Type = %s: %s", ct, w.Body)
+			}
+			if want := "id: 1\ndata: a\n\nid: 2\ndata: b\n\n"; w.Body.String() != want {
+				t.Errorf("body = %q, want %q", w.Body, want)
+			}
+		})
+	}
+}
+
+func TestServeEventsShutdown(t *testing.T) {
+	k := newTestKApi(t)
+	k.route(http.MethodGet, "/events", nil, func(c *Context) <-chan Event {
+		ch := make(chan Event, 1)
+		ch <- Event{ID: "1", Data: "a"}
+		// never closed, the stream only ends on shutdown
+		return ch
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.server = k.newServer("")
+	served := make(chan error, 1)
+	go func() {
+		served <- k.serve(func() error { return k.server.Serve(ln) })
+	}()
+	resp, err := http.Get("http://" + ln.Addr().String() + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	r := bufio.NewReader(resp.Body)
+	if line, err := r.ReadString('\n'); err != nil || line != "id: 1\n" {
+		t.Fatalf("first line = %q, %v", line, err)
+	}
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := k.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Shutdown took %v, the stream held it", d)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("serve: %v", err)
+	}
+	if rest, err := io.ReadAll(r); err != nil || string(rest) != "data: a\n\n" {
+		t.Errorf("rest of the stream = %q, %v, want a clean end", rest, err)
+	}
+}
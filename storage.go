@@ -0,0 +1,187 @@
+package kapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Storage stores uploaded files. Get returns an error wrapping fs.ErrNotExist for unknown keys
+type Storage interface {
+	// Put stores r under key. size is -1 if unknown
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes key, deleting a missing key is not an error
+	Delete(ctx context.Context, key string) error
+}
+
+// cleanKey rejects keys escaping the root of a storage
+func cleanKey(key string) (string, error) {
+	k := path.Clean("/" + strings.ReplaceAll(key, "\\", "/"))[1:]
+	if k == "" || k != strings.TrimPrefix(key, "/") {
+		return "", fmt.Errorf("storage: invalid key %q", key)
+	}
+	return k, nil
+}
+
+// LocalStorage stores files under a directory of local disk
+type LocalStorage struct {
+	Root string
+}
+
+// NewLocalStorage create a LocalStorage
+//
+//	@param root directory of files, created when needed
+//
+//	@return *LocalStorage
+func NewLocalStorage(root string) *LocalStorage {
+	return &LocalStorage{Root: root}
+}
+
+func (s *LocalStorage) path(key string) (string, error) {
+	k, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.Root, filepath.FromSlash(k)), nil
+}
+
+// Put write to a temp file and rename it, so a partial upload is never visible
+func (s *LocalStorage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), p)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+func (s *LocalStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// MemoryStorage keeps files in memory, for tests and small temporary files
+type MemoryStorage struct {
+	lock  sync.RWMutex
+	files map[string][]byte
+	types map[string]string
+}
+
+// NewMemoryStorage create an empty MemoryStorage
+//
+//	@return *MemoryStorage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		files: make(map[string][]byte),
+		types: make(map[string]string),
+	}
+}
+
+func (s *MemoryStorage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.files[key] = data
+	s.types[key] = contentType
+	return nil
+}
+
+func (s *MemoryStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	data, ok := s.files[key]
+	if !ok {
+		return nil, fmt.Errorf("storage: %s: %w", key, fs.ErrNotExist)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *MemoryStorage) Delete(ctx context.Context, key string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.files, key)
+	delete(s.types, key)
+	return nil
+}
+
+// ContentType returns the content type the file stored with
+//
+//	@param key
+//
+//	@return string
+func (s *MemoryStorage) ContentType(key string) string {
+	key, _ = cleanKey(key)
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.types[key]
+}
+
+// Keys returns keys of all stored files
+//
+//	@return []string
+func (s *MemoryStorage) Keys() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	keys := make([]string, 0, len(s.files))
+	for k := range s.files {
+		keys = append(keys, k)
+	}
+	return keys
+}
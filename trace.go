@@ -0,0 +1,206 @@
+package kapi
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/linxlib/kapi/internal"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"os"
+	"strconv"
+)
+
+// TracingOption options of tracing
+type TracingOption struct {
+	// Enable start a server span per request
+	Enable bool `yaml:"enable"`
+	// Exporter stdout or file, default stdout. k.SetTracerProvider replaces it
+	Exporter string `yaml:"exporter"`
+	// File spans are appended as JSON lines if Exporter is file, default spans.jsonl
+	File string `yaml:"file"`
+}
+
+// tracerName the instrumentation scope of kapi's spans
+const tracerName = "github.com/linxlib/kapi"
+
+// propagator reads and writes traceparent and tracestate
+var propagator propagation.TextMapPropagator = propagation.TraceContext{}
+
+// SpanFromContext returns the current span in ctx, e.g. the injected context.Context of a handler
+//
+//	@param ctx
+//
+//	@return trace.Span a no-op span if tracing is disabled
+func SpanFromContext(ctx context.Context) trace.Span {
+	return trace.SpanFromContext(ctx)
+}
+
+// StartSpan start a child span of the span in ctx with the tracer provider of it, call End when done:
+//
+//	ctx, span := kapi.StartSpan(ctx, "query users")
+//	defer span.End()
+//
+// if tracing is disabled, the span is a no-op
+//
+//	@param ctx
+//	@param name
+//	@param opts e.g. trace.WithAttributes
+//
+//	@return context.Context ctx with the new span
+//	@return trace.Span
+func StartSpan(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return trace.SpanFromContext(ctx).TracerProvider().Tracer(tracerName).Start(ctx, name, opts...)
+}
+
+// endSpan set the status of err and end the span
+func endSpan(s trace.Span, err error) {
+	if err != nil {
+		s.RecordError(err)
+		s.SetStatus(codes.Error, err.Error())
+	}
+	s.End()
+}
+
+// SetTracerProvider set where spans are made and exported, it replaces the exporter of server.tracing.
+// it's shut down with the server if it has a Shutdown(ctx) error method
+//
+//	@param tp e.g. a *sdktrace.TracerProvider with an OTLP exporter
+func (b *KApi) SetTracerProvider(tp trace.TracerProvider) {
+	b.tracerProvider = tp
+	if s, ok := tp.(interface{ Shutdown(context.Context) error }); ok {
+		b.addCleanup(func() {
+			if err := s.Shutdown(context.Background()); err != nil {
+				internal.Errorf("tracing: %s", err)
+			}
+		})
+	}
+}
+
+// traceIDGenerator uses the trace id of the request for root spans, so it matches c.TraceID() and
+// the request ID generated from it
+type traceIDGenerator struct{}
+
+func (g traceIDGenerator) NewIDs(ctx context.Context) (trace.TraceID, trace.SpanID) {
+	tid, _ := trace.TraceIDFromHex(randomHex(16))
+	if r, ok := ctx.Value(requestIDKey{}).(*correlation); ok {
+		if id, err := trace.TraceIDFromHex(r.traceID); err == nil {
+			tid = id
+		}
+	}
+	return tid, g.NewSpanID(ctx, tid)
+}
+
+func (traceIDGenerator) NewSpanID(ctx context.Context, traceID trace.TraceID) trace.SpanID {
+	sid, _ := trace.SpanIDFromHex(randomHex(8))
+	return sid
+}
+
+// newTracerProvider a tracer provider exporting spans to w as JSON lines. sampling follows the sampled flag
+// of traceparent, requests without it are sampled
+func newTracerProvider(w io.Writer) (*sdktrace.TracerProvider, error) {
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(w))
+	if err != nil {
+		return nil, err
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.AlwaysSample())),
+		sdktrace.WithIDGenerator(traceIDGenerator{}),
+	), nil
+}
+
+// initTracing create the tracer provider of server.tracing
+func (b *KApi) initTracing() {
+	opt := b.option.Server.Tracing
+	if !opt.Enable {
+		return
+	}
+	var w io.Writer
+	switch opt.Exporter {
+	case "", "stdout":
+		w = os.Stdout
+	case "file":
+		name := opt.File
+		if name == "" {
+			name = "spans.jsonl"
+		}
+		f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			internal.Errorf("tracing: %s", err)
+			return
+		}
+		// cleanups run in reverse order, the provider flushes before the file is closed
+		b.addCleanup(func() { _ = f.Close() })
+		w = f
+	default:
+		internal.Errorf("tracing: unknown exporter %q", opt.Exporter)
+		return
+	}
+	tp, err := newTracerProvider(w)
+	if err != nil {
+		internal.Errorf("tracing: %s", err)
+		return
+	}
+	b.SetTracerProvider(tp)
+}
+
+// tracingHandler start the server span of the request. the trace and parent are taken from traceparent
+func (b *KApi) tracingHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if b.tracerProvider == nil {
+			c.Next()
+			return
+		}
+		route := b.routeOf(c)
+		ctx := propagator.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		ctx, s := b.tracerProvider.Tracer(tracerName).Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", c.Request.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", c.Request.URL.Path),
+				attribute.String("client.address", c.ClientIP()),
+				attribute.String("request.id", requestID(c)),
+			))
+		if r := correlationOf(c); r != nil && s.SpanContext().IsValid() {
+			// a tracer provider of the app may not use the trace id of the request
+			r.traceID = s.SpanContext().TraceID().String()
+		}
+		c.Request = c.Request.WithContext(ctx)
+		defer s.End()
+		c.Next()
+		status := c.Writer.Status()
+		s.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= 500 {
+			s.SetStatus(codes.Error, "status "+strconv.Itoa(status))
+		}
+	}
+}
+
+// trace run f in a child span of the current span of c, spans started in f are its children.
+// f may replace c.Request or its context, only the span is taken off afterwards
+func (c *Context) trace(name string, f func() error) {
+	ctx := c.Request.Context()
+	parent := trace.SpanFromContext(ctx)
+	if !parent.IsRecording() {
+		_ = f()
+		return
+	}
+	child, s := parent.TracerProvider().Tracer(tracerName).Start(ctx, name)
+	c.Request = c.Request.WithContext(child)
+	var err error
+	defer func() {
+		if cur := c.Request.Context(); cur == child {
+			c.Request = c.Request.WithContext(ctx)
+		} else {
+			c.Request = c.Request.WithContext(trace.ContextWithSpan(cur, parent))
+		}
+		endSpan(s, err)
+	}()
+	err = f()
+}
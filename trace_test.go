@@ -0,0 +1,193 @@
+package kapi
+
+import (
+	"context"
+	"encoding/json"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type traceCtxKey struct{}
+
+// traceController a BeforeCall hook adding a value to the request context
+type traceController struct{}
+
+func (traceController) BeforeCall(c *Context) {
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), traceCtxKey{}, "hook"))
+}
+
+// newTracedKApi create a KApi with a recorded tracer provider and a traced GET /api/items/:id of Test.Get
+func newTracedKApi(t *testing.T, handler any) (*KApi, *tracetest.SpanRecorder) {
+	t.Helper()
+	k := newTestKApi(t, WithServer(func(s *ServerOption) {
+		s.BasePath = "/api"
+	}))
+	sr := tracetest.NewSpanRecorder()
+	k.SetTracerProvider(sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(sr),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.AlwaysSample())),
+		sdktrace.WithIDGenerator(traceIDGenerator{}),
+	))
+	err := k.registerMethodToRouter(RouteItem{Method: "GET", RouterPath: "/items/:id"}, "Test.Get", traceController{}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k, sr
+}
+
+func spanNamed(spans []sdktrace.ReadOnlySpan, name string) sdktrace.ReadOnlySpan {
+	for _, s := range spans {
+		if s.Name() == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func TestTracingSpans(t *testing.T) {
+	var hookValue any
+	var callSpan trace.SpanContext
+	k, sr := newTracedKApi(t, func(c *Context) (any, error) {
+		hookValue = c.Request.Context().Value(traceCtxKey{})
+		callSpan = trace.SpanContextFromContext(c.Request.Context())
+		return "ok", nil
+	})
+	w := serve(k, httptest.NewRequest(http.MethodGet, "/api/items/1", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	if hookValue != "hook" {
+		t.Errorf("value of the BeforeCall context = %v, the context of hooks is lost", hookValue)
+	}
+	spans := sr.Ended()
+	server := spanNamed(spans, "Test.Get")
+	if server == nil {
+		t.Fatalf("no server span in %d spans", len(spans))
+	}
+	if server.SpanKind() != trace.SpanKindServer {
+		t.Errorf("kind = %v", server.SpanKind())
+	}
+	if got := server.SpanContext().TraceID().String(); got != w.Header().Get(HeaderRequestID) {
+		t.Errorf("trace id %s is not the generated request id %s", got, w.Header().Get(HeaderRequestID))
+	}
+	for _, attr := range server.Attributes() {
+		if attr.Key == "http.route" && attr.Value.AsString() != "/items/:id" {
+			t.Errorf("http.route = %s", attr.Value.AsString())
+		}
+	}
+	for _, name := range []string{"BeforeCall", "call"} {
+		s := spanNamed(spans, name)
+		if s == nil {
+			t.Fatalf("no %s span", name)
+		}
+		if s.Parent().SpanID() != server.SpanContext().SpanID() {
+			t.Errorf("parent of %s is not the server span", name)
+		}
+	}
+	if callSpan.SpanID() != spanNamed(spans, "call").SpanContext().SpanID() {
+		t.Error("the handler context does not carry the call span")
+	}
+}
+
+func TestTracingSampled(t *testing.T) {
+	const traceID, parentID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	tests := []struct {
+		version string
+		flags   string
+		sampled bool
+	}{
+		{"00", "01", true},
+		{"00", "00", false},
+		{"00", "02", false}, // only the sampled bit counts
+		{"01", "03", true},  // flags of later versions
+	}
+	for _, tt := range tests {
+		t.Run(tt.version+"-"+tt.flags, func(t *testing.T) {
+			var forwarded string
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwarded = r.Header.Get(HeaderTraceParent)
+			}))
+			defer upstream.Close()
+			k, sr := newTracedKApi(t, func(c *Context) (any, error) {
+				resp, err := c.HTTPClient().Get(upstream.URL)
+				if err != nil {
+					return nil, err
+				}
+				return nil, resp.Body.Close()
+			})
+			req := httptest.NewRequest(http.MethodGet, "/api/items/1", nil)
+			req.Header.Set(HeaderTraceParent, tt.version+"-"+traceID+"-"+parentID+"-"+tt.flags)
+			serve(k, req)
+			server := spanNamed(sr.Ended(), "Test.Get")
+			if (server != nil) != tt.sampled {
+				t.Fatalf("server span recorded = %v, want %v", server != nil, tt.sampled)
+			}
+			tid, pid, flags, ok := parseTraceParent(forwarded)
+			if !ok || tid != traceID {
+				t.Fatalf("forwarded traceparent = %q", forwarded)
+			}
+			if tt.sampled {
+				client := spanNamed(sr.Ended(), "HTTP GET")
+				if server.Parent().SpanID().String() != parentID || server.SpanContext().TraceID().String() != traceID {
+					t.Errorf("server span is not continued from traceparent")
+				}
+				if client == nil || pid != client.SpanContext().SpanID().String() || flags != "01" {
+					t.Errorf("forwarded traceparent %q is not of the client span", forwarded)
+				}
+			} else if flags != "00" {
+				t.Errorf("forwarded flags = %s, want 00", flags)
+			}
+		})
+	}
+}
+
+func TestTracingDisabled(t *testing.T) {
+	k := newTestKApi(t)
+	var span trace.Span
+	k.route(http.MethodGet, "/x", nil, func(c *Context) (any, error) {
+		_, span = StartSpan(c.Request.Context(), "inner")
+		defer span.End()
+		return "ok", nil
+	})
+	if w := serve(k, httptest.NewRequest(http.MethodGet, "/x", nil)); w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	if span == nil || span.IsRecording() {
+		t.Error("span of disabled tracing should be a no-op")
+	}
+}
+
+func TestTracingFileExporter(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "spans.jsonl")
+	k := newTestKApi(t, WithServer(func(s *ServerOption) {
+		s.Tracing = TracingOption{Enable: true, Exporter: "file", File: file}
+	}))
+	k.route(http.MethodGet, "/x", nil, func(c *Context) (any, error) {
+		return "ok", nil
+	})
+	serve(k, httptest.NewRequest(http.MethodGet, "/x", nil))
+	// spans are flushed on shutdown
+	k.runCleanups()
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var span struct{ Name string }
+		if err := json.Unmarshal([]byte(line), &span); err != nil {
+			t.Fatalf("bad span %q: %v", line, err)
+		}
+		names = append(names, span.Name)
+	}
+	if strings.Join(names, ",") != "call,Test.Handler" {
+		t.Errorf("exported spans %v", names)
+	}
+}
@@ -0,0 +1,363 @@
+package kapi
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	binding2 "github.com/gin-gonic/gin/binding"
+	"io"
+	"math"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UploadOption limits of streamed uploads, can be overwritten per field by the `upload` tag
+type UploadOption struct {
+	// MaxSize max bytes of a file, default 32MB
+	MaxSize int64 `yaml:"maxSize"`
+	// AllowedTypes sniffed content types allowed, e.g. image/* or application/pdf. empty allows all
+	AllowedTypes []string `yaml:"allowedTypes"`
+	// Dir root of the default local storage, default uploads
+	Dir string `yaml:"dir"`
+	// MaxParts max parts of a streamed multipart form, files included, default 1000
+	MaxParts int `yaml:"maxParts"`
+	// MaxValuesSize max bytes of all non-file parts of a streamed multipart form, default 10MB
+	MaxValuesSize int64 `yaml:"maxValuesSize"`
+}
+
+// maxValueSize max bytes of a non-file part of a streamed multipart form
+const maxValueSize = 1 << 20
+
+var fileHeaderTypes = []reflect.Type{
+	reflect.TypeOf((*multipart.FileHeader)(nil)),
+	reflect.TypeOf(multipart.FileHeader{}),
+	reflect.TypeOf([]*multipart.FileHeader(nil)),
+	reflect.TypeOf([]multipart.FileHeader(nil)),
+}
+
+// UploadedFile a file streamed into Storage while binding. use it as a `form` field of the request param:
+//
+//	type UploadReq struct {
+//		Avatar *kapi.UploadedFile   `form:"avatar" upload:"maxSize=2MB,types=image/png|image/jpeg"`
+//		Files  []*kapi.UploadedFile `form:"files"`
+//		Name   string               `form:"name"`
+//	}
+type UploadedFile struct {
+	Field       string `json:"field"`       // form field name
+	Filename    string `json:"filename"`    // file name sent by client
+	Size        int64  `json:"size"`        // bytes
+	ContentType string `json:"contentType"` // sniffed from the content, not the one sent by client
+	SHA256      string `json:"sha256"`      // hex encoded checksum
+	Key         string `json:"key"`         // key in the Storage
+	storage     Storage
+}
+
+var uploadedFileType = reflect.TypeOf((*UploadedFile)(nil))
+
+// Open read the stored file
+//
+//	@param ctx
+//
+//	@return io.ReadCloser
+//	@return error
+func (f *UploadedFile) Open(ctx context.Context) (io.ReadCloser, error) {
+	return f.storage.Get(ctx, f.Key)
+}
+
+// Remove delete the stored file, e.g. when a later step of the request failed
+//
+//	@param ctx
+//
+//	@return error
+func (f *UploadedFile) Remove(ctx context.Context) error {
+	return f.storage.Delete(ctx, f.Key)
+}
+
+// SetStorage set where uploaded files are stored, default is a LocalStorage of Server.Upload.Dir.
+// the storage is also injectable as kapi.Storage
+//
+//	@param storage LocalStorage, MemoryStorage, S3Storage or your own
+func (b *KApi) SetStorage(storage Storage) {
+	b.storage = storage
+	b.MapTo(storage, (*Storage)(nil))
+}
+
+// Storage returns the storage of uploaded files
+//
+//	@return Storage
+func (b *KApi) Storage() Storage {
+	return b.storage
+}
+
+// uploadLimit limits of one file field
+type uploadLimit struct {
+	maxSize int64
+	types   []string
+}
+
+// parseUploadTag parse `upload:"maxSize=2MB,types=image/png|image/jpeg"` over the default limits
+func parseUploadTag(tag string, def UploadOption) (uploadLimit, error) {
+	limit := uploadLimit{maxSize: def.MaxSize, types: def.AllowedTypes}
+	for _, item := range strings.Split(tag, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(strings.TrimSpace(item), "=")
+		switch k {
+		case "maxSize":
+			n, err := parseSize(v)
+			if err != nil {
+				return limit, err
+			}
+			limit.maxSize = n
+		case "types":
+			limit.types = strings.Split(v, "|")
+		default:
+			return limit, fmt.Errorf("upload:%q: unknown option %s", tag, k)
+		}
+	}
+	if limit.maxSize <= 0 {
+		limit.maxSize = 32 << 20
+	}
+	return limit, nil
+}
+
+// checkUploadTags parse the upload tags of the request param of method, so malformed limits fail on registration.
+// multipart.FileHeader fields can't be bound from a streamed form, so they fail with *UploadedFile fields
+func checkUploadTags(method any) error {
+	typ := reflect.TypeOf(method)
+	if typ.NumIn() < 2 || typ.In(1) == wsConnType {
+		return nil
+	}
+	reqType := typ.In(1)
+	for reqType.Kind() == reflect.Ptr {
+		reqType = reqType.Elem()
+	}
+	var err error
+	var fileHeader string
+	obj := reflect.New(reqType).Interface()
+	walkTaggedFields(obj, "form", func(name string, _ reflect.Value, sf reflect.StructField) {
+		for _, t := range fileHeaderTypes {
+			if sf.Type == t {
+				fileHeader = name
+			}
+		}
+		if tag, ok := sf.Tag.Lookup("upload"); ok && err == nil {
+			if _, err = parseUploadTag(tag, UploadOption{}); err != nil {
+				err = fmt.Errorf("field %s: %w", name, err)
+			}
+		}
+	})
+	if err == nil && fileHeader != "" && hasUploadFields(obj) {
+		err = fmt.Errorf("field %s: multipart.FileHeader can't be used with *UploadedFile fields, use *UploadedFile", fileHeader)
+	}
+	return err
+}
+
+// parseSize parse 1024, 512KB, 2MB or 1GB
+func parseSize(s string) (int64, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	unit := int64(1)
+	for _, u := range []struct {
+		suffix string
+		n      int64
+	}{{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}} {
+		if strings.HasSuffix(v, u.suffix) {
+			v, unit = strings.TrimSuffix(v, u.suffix), u.n
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	if err != nil || n <= 0 || n > math.MaxInt64/unit {
+		return 0, fmt.Errorf("invalid size %q, want a positive number with an optional KB, MB or GB", s)
+	}
+	return n * unit, nil
+}
+
+// allowed check content type against patterns like image/*
+func (l uploadLimit) allowed(contentType string) bool {
+	if len(l.types) == 0 {
+		return true
+	}
+	mt, _, _ := mime.ParseMediaType(contentType)
+	for _, t := range l.types {
+		t = strings.TrimSpace(t)
+		if t == "*/*" || t == mt || strings.HasSuffix(t, "/*") && strings.HasPrefix(mt, strings.TrimSuffix(t, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasUploadFields check if obj has *UploadedFile fields with `form` tag
+func hasUploadFields(obj any) bool {
+	found := false
+	walkTaggedFields(obj, "form", func(name string, field reflect.Value, _ reflect.StructField) {
+		if t := field.Type(); t == uploadedFileType || t.Kind() == reflect.Slice && t.Elem() == uploadedFileType {
+			found = true
+		}
+	})
+	return found
+}
+
+var errFileTooLarge = errors.New("file too large")
+
+// sizeLimitReader fails once more than n bytes are read
+type sizeLimitReader struct {
+	r    io.Reader
+	n    int64
+	read int64
+}
+
+func (l *sizeLimitReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.read > l.n {
+		return n, errFileTooLarge
+	}
+	return n, err
+}
+
+// streamMultipart read the multipart body part by part, files of *UploadedFile fields are streamed to storage
+// without buffering the whole file. stored files are deleted if binding fails
+func (b *KApi) streamMultipart(c *Context, obj any) (err error) {
+	mr, err := c.Request.MultipartReader()
+	if err != nil {
+		return err
+	}
+	fields := make(map[string]reflect.Value)
+	limits := make(map[string]uploadLimit)
+	walkTaggedFields(obj, "form", func(name string, field reflect.Value, sf reflect.StructField) {
+		if t := field.Type(); t == uploadedFileType || t.Kind() == reflect.Slice && t.Elem() == uploadedFileType {
+			fields[name] = field
+			// tags are checked on registration
+			limits[name], _ = parseUploadTag(sf.Tag.Get("upload"), b.option.Server.Upload)
+		}
+	})
+	var stored []*UploadedFile
+	defer func() {
+		if err != nil {
+			for _, f := range stored {
+				_ = f.Remove(context.Background())
+			}
+		}
+	}()
+	maxParts, valuesLeft := b.option.Server.Upload.MaxParts, b.option.Server.Upload.MaxValuesSize
+	if maxParts <= 0 {
+		maxParts = 1000
+	}
+	if valuesLeft <= 0 {
+		valuesLeft = 10 << 20
+	}
+	values := make(map[string][]string)
+	for parts := 0; ; parts++ {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if parts == maxParts {
+			return NewStatusError(http.StatusRequestEntityTooLarge, "multipart form has more than "+strconv.Itoa(maxParts)+" parts")
+		}
+		name := part.FormName()
+		field, isFile := fields[name]
+		switch {
+		case part.FileName() == "":
+			v, err := io.ReadAll(io.LimitReader(part, min(maxValueSize, valuesLeft)+1))
+			if err != nil {
+				return err
+			}
+			if len(v) > maxValueSize {
+				return ValidationErrors{{Field: name, Rule: "maxSize", Param: strconv.Itoa(maxValueSize), Message: name + " is too large"}}
+			}
+			if valuesLeft -= int64(len(v)); valuesLeft < 0 {
+				return NewStatusError(http.StatusRequestEntityTooLarge, "values of multipart form are too large")
+			}
+			values[name] = append(values[name], string(v))
+		case isFile:
+			f, err := b.saveUpload(c, part, limits[name])
+			if err != nil {
+				return err
+			}
+			stored = append(stored, f)
+			if field.Kind() == reflect.Slice {
+				field.Set(reflect.Append(field, reflect.ValueOf(f)))
+			} else if field.IsNil() {
+				field.Set(reflect.ValueOf(f))
+			} else {
+				// only the first file is kept for a single file field
+				_ = f.Remove(c.Request.Context())
+				stored = stored[:len(stored)-1]
+			}
+		}
+		_ = part.Close()
+	}
+	return binding2.MapFormWithTag(obj, values, "form")
+}
+
+// saveUpload sniff, check, hash and store one file part
+func (b *KApi) saveUpload(c *Context, part *multipart.Part, limit uploadLimit) (*UploadedFile, error) {
+	name := part.FormName()
+	br := bufio.NewReaderSize(part, 512)
+	head, err := br.Peek(512)
+	if err != nil && err != io.EOF && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, err
+	}
+	contentType := http.DetectContentType(head)
+	if !limit.allowed(contentType) {
+		return nil, ValidationErrors{{
+			Field:   name,
+			Rule:    "types",
+			Param:   strings.Join(limit.types, "|"),
+			Message: name + ": content type " + contentType + " is not allowed",
+		}}
+	}
+	f := &UploadedFile{
+		Field:       name,
+		Filename:    path.Base(strings.ReplaceAll(part.FileName(), "\\", "/")),
+		ContentType: contentType,
+		Key:         newUploadKey(part.FileName()),
+		storage:     b.storage,
+	}
+	h := sha256.New()
+	lr := &sizeLimitReader{r: br, n: limit.maxSize}
+	err = b.storage.Put(c.Request.Context(), f.Key, io.TeeReader(lr, h), -1, contentType)
+	if err != nil {
+		_ = b.storage.Delete(context.Background(), f.Key)
+		if errors.Is(err, errFileTooLarge) || lr.read > lr.n {
+			return nil, ValidationErrors{{
+				Field:   name,
+				Rule:    "maxSize",
+				Param:   strconv.FormatInt(limit.maxSize, 10),
+				Message: name + ": file is larger than " + strconv.FormatInt(limit.maxSize, 10) + " bytes",
+			}}
+		}
+		return nil, err
+	}
+	f.Size = lr.read
+	f.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return f, nil
+}
+
+// newUploadKey returns a random key under a date directory, the extension of filename is kept
+func newUploadKey(filename string) string {
+	var bs [16]byte
+	_, _ = rand.Read(bs[:])
+	ext := strings.ToLower(path.Ext(strings.ReplaceAll(filename, "\\", "/")))
+	if len(ext) > 16 || strings.ContainsAny(ext, "/?#%") {
+		ext = ""
+	}
+	return time.Now().Format("2006/01/02") + "/" + hex.EncodeToString(bs[:]) + ext
+}
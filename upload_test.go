@@ -0,0 +1,142 @@
+package kapi
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"1024", 1024, false},
+		{"512KB", 512 << 10, false},
+		{"2mb", 2 << 20, false},
+		{" 1 GB ", 1 << 30, false},
+		{"", 0, true},
+		{"2M", 0, true},
+		{"abc", 0, true},
+		{"-1MB", 0, true},
+		{"0", 0, true},
+		{"9999999999GB", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.in)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("parseSize(%q) = %d, %v, want %d, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseUploadTag(t *testing.T) {
+	def := UploadOption{MaxSize: 1 << 20, AllowedTypes: []string{"image/*"}}
+	tests := []struct {
+		tag     string
+		want    uploadLimit
+		wantErr bool
+	}{
+		{"", uploadLimit{maxSize: 1 << 20, types: []string{"image/*"}}, false},
+		{"maxSize=2MB", uploadLimit{maxSize: 2 << 20, types: []string{"image/*"}}, false},
+		{"maxSize=2MB, types=image/png|application/pdf", uploadLimit{maxSize: 2 << 20, types: []string{"image/png", "application/pdf"}}, false},
+		{"maxSize=2XB", uploadLimit{}, true},
+		{"maxsize=2MB", uploadLimit{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseUploadTag(tt.tag, def)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseUploadTag(%q) err = %v", tt.tag, err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseUploadTag(%q) = %+v, want %+v", tt.tag, got, tt.want)
+		}
+	}
+}
+
+func TestCheckUploadTags(t *testing.T) {
+	type good struct {
+		Avatar *UploadedFile `form:"avatar" upload:"maxSize=2MB"`
+	}
+	type bad struct {
+		Avatar *UploadedFile `form:"avatar" upload:"maxSize=2 megabytes"`
+	}
+	if err := checkUploadTags(func(c *Context, req *good) {}); err != nil {
+		t.Errorf("good tag: %v", err)
+	}
+	if err := checkUploadTags(func(c *Context, req bad) {}); err == nil {
+		t.Error("malformed tag is not rejected")
+	}
+	if err := checkUploadTags(func(c *Context) {}); err != nil {
+		t.Errorf("no request: %v", err)
+	}
+	type mixed struct {
+		Avatar *UploadedFile         `form:"avatar"`
+		Doc    *multipart.FileHeader `form:"doc"`
+	}
+	if err := checkUploadTags(func(c *Context, req *mixed) {}); err == nil || !strings.Contains(err.Error(), "field doc") {
+		t.Errorf("multipart.FileHeader with *UploadedFile: err = %v", err)
+	}
+	type headers struct {
+		Docs []*multipart.FileHeader `form:"docs"`
+	}
+	if err := checkUploadTags(func(c *Context, req *headers) {}); err != nil {
+		t.Errorf("multipart.FileHeader only: %v", err)
+	}
+}
+
+type streamedReq struct {
+	File  *UploadedFile `form:"file"`
+	Names []string      `form:"name"`
+}
+
+func TestStreamMultipartLimits(t *testing.T) {
+	tests := []struct {
+		name       string
+		valuesSize int64
+		values     []string // values of name, a file part is sent first
+		status     int
+	}{
+		{"within limits", 100, []string{"a", "b"}, http.StatusOK},
+		{"too many parts", 100, []string{"a", "b", "c", "d"}, http.StatusRequestEntityTooLarge},
+		{"values too large", 100, []string{strings.Repeat("a", 60), strings.Repeat("b", 60)}, http.StatusRequestEntityTooLarge},
+		{"value too large", 2 << 20, []string{strings.Repeat("a", maxValueSize+1)}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newTestKApi(t, WithServer(func(s *ServerOption) {
+				s.Upload.MaxParts = 4
+				s.Upload.MaxValuesSize = tt.valuesSize
+			}))
+			k.SetStorage(NewMemoryStorage())
+			var got *streamedReq
+			k.route(http.MethodPost, "/upload", nil, func(c *Context, req *streamedReq) (any, error) {
+				got = req
+				return "ok", nil
+			})
+			var body bytes.Buffer
+			mw := multipart.NewWriter(&body)
+			fw, _ := mw.CreateFormFile("file", "a.txt")
+			_, _ = fw.Write([]byte("hello"))
+			for _, v := range tt.values {
+				_ = mw.WriteField("name", v)
+			}
+			_ = mw.Close()
+			req := httptest.NewRequest(http.MethodPost, "/upload", &body)
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+			w := serve(k, req)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status == http.StatusOK && (got.File == nil || got.File.Size != 5 || !reflect.DeepEqual(got.Names, tt.values)) {
+				t.Errorf("bound %+v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package kapi
+
+import (
+	"encoding/json"
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/zh"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	en_translations "github.com/go-playground/validator/v10/translations/en"
+	zh_translations "github.com/go-playground/validator/v10/translations/zh"
+	binding3 "github.com/linxlib/binding"
+	"github.com/linxlib/kapi/internal"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// ValidationError one field failed to validate or unmarshal
+type ValidationError struct {
+	Field   string `json:"field"` // path of the field, uses json/query/path/header/form tag names. e.g. items[0].name
+	Rule    string `json:"rule"`  // validation rule, "type" for unmarshal type errors
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"` // translated message
+}
+
+// ValidationErrors all fields failed to validate
+type ValidationErrors []ValidationError
+
+func (v ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(v))
+	for _, e := range v {
+		msgs = append(msgs, e.Message)
+	}
+	return strings.Join(msgs, ";")
+}
+
+// Messages returns the messages of every field
+//
+//	@return []string
+func (v ValidationErrors) Messages() []string {
+	msgs := make([]string, 0, len(v))
+	for _, e := range v {
+		msgs = append(msgs, e.Message)
+	}
+	return msgs
+}
+
+// MessageCatalog translates validation errors
+type MessageCatalog interface {
+	// Translate returns the message of fe in the first supported language of langs
+	Translate(langs []string, fe validator.FieldError) string
+}
+
+// ValidationMessages the default MessageCatalog. uses the translations of validator (en, zh),
+// messages added by Add take precedence
+type ValidationMessages struct {
+	mu       sync.RWMutex
+	uni      *ut.UniversalTranslator
+	messages map[string]map[string]string
+}
+
+var tagNameOnce sync.Once
+
+// NewValidationMessages create a catalog with en and zh translations
+//
+//	@param fallback language used when Accept-Language is not supported. "zh" or "en"
+//
+//	@return *ValidationMessages
+func NewValidationMessages(fallback string) *ValidationMessages {
+	tagNameOnce.Do(registerTagName)
+	enLocale, zhLocale := en.New(), zh.New()
+	uni := ut.New(zhLocale, zhLocale, enLocale)
+	if fallback == "en" {
+		uni = ut.New(enLocale, enLocale, zhLocale)
+	}
+	v := binding3.Validator.Engine().(*validator.Validate)
+	if trans, ok := uni.GetTranslator("en"); ok {
+		_ = en_translations.RegisterDefaultTranslations(v, trans)
+	}
+	if trans, ok := uni.GetTranslator("zh"); ok {
+		_ = zh_translations.RegisterDefaultTranslations(v, trans)
+	}
+	return &ValidationMessages{
+		uni:      uni,
+		messages: make(map[string]map[string]string),
+	}
+}
+
+// Add a message of a rule for a language, {field} and {param} will be replaced
+//
+//	@param lang e.g. en
+//	@param rule e.g. required
+//	@param message e.g. {field} is required
+func (m *ValidationMessages) Add(lang string, rule string, message string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	lang = strings.ToLower(lang)
+	if m.messages[lang] == nil {
+		m.messages[lang] = make(map[string]string)
+	}
+	m.messages[lang][rule] = message
+}
+
+func (m *ValidationMessages) Translate(langs []string, fe validator.FieldError) string {
+	m.mu.RLock()
+	for _, lang := range langs {
+		if msg, ok := m.messages[lang][fe.Tag()]; ok {
+			m.mu.RUnlock()
+			return strings.NewReplacer("{field}", fe.Field(), "{param}", fe.Param()).Replace(msg)
+		}
+	}
+	m.mu.RUnlock()
+	trans, _ := m.uni.FindTranslator(langs...)
+	return fe.Translate(trans)
+}
+
+// parseAcceptLanguage returns languages of Accept-Language in order, zh-CN will be zh_cn and zh
+func parseAcceptLanguage(header string) []string {
+	langs := make([]string, 0)
+	for _, part := range strings.Split(header, ",") {
+		lang, _, _ := strings.Cut(strings.TrimSpace(part), ";")
+		lang = strings.ToLower(strings.ReplaceAll(lang, "-", "_"))
+		if lang == "" || lang == "*" {
+			continue
+		}
+		langs = append(langs, lang)
+		if base, _, ok := strings.Cut(lang, "_"); ok {
+			langs = append(langs, base)
+		}
+	}
+	return langs
+}
+
+// registerTagName makes validator report the names used by binders instead of go field names
+func registerTagName() {
+	v := binding3.Validator.Engine().(*validator.Validate)
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		for _, tag := range []string{"json", "query", "path", "uri", "header", "form", "cookie"} {
+			name, _, _ := strings.Cut(field.Tag.Get(tag), ",")
+			if name == "-" {
+				return ""
+			}
+			if name != "" {
+				return name
+			}
+		}
+		return field.Name
+	})
+}
+
+// SetMessageCatalog replace the catalog of validation messages
+//
+//	@param catalog
+func (b *KApi) SetMessageCatalog(catalog MessageCatalog) {
+	b.messages = catalog
+}
+
+// AddValidationMessage add a message to the default catalog. see ValidationMessages.Add
+//
+//	@param lang
+//	@param rule
+//	@param message
+func (b *KApi) AddValidationMessage(lang string, rule string, message string) {
+	if m, ok := b.messages.(*ValidationMessages); ok {
+		m.Add(lang, rule, message)
+	} else {
+		internal.Warnf("custom MessageCatalog in use, message of %s ignored", rule)
+	}
+}
+
+// validationErrors convert errors of validator or json to ValidationErrors
+func (b *KApi) validationErrors(c *Context, err error) (ValidationErrors, bool) {
+	switch e := err.(type) {
+	case ValidationErrors:
+		return e, true
+	case validator.ValidationErrors:
+		langs := parseAcceptLanguage(c.GetHeader("Accept-Language"))
+		errs := make(ValidationErrors, 0, len(e))
+		for _, fe := range e {
+			field := fe.Namespace()
+			if _, after, ok := strings.Cut(field, "."); ok {
+				field = after //remove the name of request type
+			}
+			errs = append(errs, ValidationError{
+				Field:   field,
+				Rule:    fe.Tag(),
+				Param:   fe.Param(),
+				Message: b.messages.Translate(langs, fe),
+			})
+		}
+		return errs, true
+	case *json.UnmarshalTypeError:
+		return ValidationErrors{{
+			Field:   e.Field,
+			Rule:    "type",
+			Param:   e.Type.String(),
+			Message: e.Field + ":" + e.Type.String() + "(but[" + e.Value + "])",
+		}}, true
+	}
+	return nil, false
+}
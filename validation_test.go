@@ -0,0 +1,117 @@
+package kapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type validationItem struct {
+	Name string `json:"name" binding:"required"`
+}
+
+type validationReq struct {
+	ID    int              `path:"id" binding:"min=10"`
+	Items []validationItem `json:"items" binding:"dive"`
+}
+
+func TestValidationErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		lang   string
+		custom bool // with a message added by AddValidationMessage
+		path   string
+		body   string
+		want   ValidationErrors
+	}{
+		{
+			name: "fields and params",
+			lang: "en",
+			path: "/items/1",
+			body: `{"items":[{"name":"a"},{}]}`,
+			want: ValidationErrors{
+				{Field: "id", Rule: "min", Param: "10", Message: "id must be 10 or greater"},
+				{Field: "items[1].name", Rule: "required", Message: "name is a required field"},
+			},
+		},
+		{
+			name: "zh of Accept-Language",
+			lang: "zh-CN,en;q=0.5",
+			path: "/items/10",
+			body: `{"items":[{}]}`,
+			want: ValidationErrors{
+				{Field: "items[0].name", Rule: "required", Message: "name为必填字段"},
+			},
+		},
+		{
+			name:   "added message",
+			lang:   "en",
+			custom: true,
+			path:   "/items/1",
+			body:   `{}`,
+			want: ValidationErrors{
+				{Field: "id", Rule: "min", Param: "10", Message: "id is at least 10"},
+			},
+		},
+		{
+			name: "type",
+			lang: "en",
+			path: "/items/10",
+			body: `{"items":"a"}`,
+			want: ValidationErrors{
+				{Field: "items", Rule: "type", Param: "[]kapi.validationItem", Message: "items:[]kapi.validationItem(but[string])"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newTestKApi(t)
+			if tt.custom {
+				k.AddValidationMessage("en", "min", "{field} is at least {param}")
+			}
+			k.route(http.MethodPost, "/items/:id", nil, func(c *Context, req *validationReq) (any, error) {
+				return "ok", nil
+			})
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Accept-Language", tt.lang)
+			w := serve(k, req)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d: %s", w.Code, w.Body)
+			}
+			var body struct {
+				Msg  string           `json:"msg"`
+				Data ValidationErrors `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(body.Data, tt.want) {
+				t.Errorf("data = %+v, want %+v", body.Data, tt.want)
+			}
+			if body.Msg != tt.want.Error() {
+				t.Errorf("msg = %q, want %q", body.Msg, tt.want.Error())
+			}
+		})
+	}
+}
+
+func TestParseAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []string
+	}{
+		{"", []string{}},
+		{"en", []string{"en"}},
+		{"zh-CN,zh;q=0.9,en;q=0.8", []string{"zh_cn", "zh", "zh", "en"}},
+		{"*, en-US", []string{"en_us", "en"}},
+	}
+	for _, tt := range tests {
+		if got := parseAcceptLanguage(tt.header); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseAcceptLanguage(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
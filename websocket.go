@@ -0,0 +1,205 @@
+package kapi
+
+import (
+	"encoding/json"
+	"github.com/linxlib/kapi/internal/websocket"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// websocket message types and close codes
+const (
+	WSTextMessage   = websocket.TextMessage
+	WSBinaryMessage = websocket.BinaryMessage
+
+	WSCloseNormal    = websocket.CloseNormalClosure
+	WSCloseGoingAway = websocket.CloseGoingAway
+)
+
+// WSCloseError returned by read methods when client closed the connection
+type WSCloseError = websocket.CloseError
+
+// WSConn a websocket connection of a @WS route. add it to the params of a controller method:
+//
+//	// @WS /chat
+//	func (e *Example) Chat(c *kapi.Context, ws *kapi.WSConn) {
+//		for {
+//			var msg ChatMessage
+//			if err := ws.ReadJSON(&msg); err != nil {
+//				return
+//			}
+//			_ = ws.WriteJSON(msg)
+//		}
+//	}
+//
+// the connection is closed when the method returns. the Context lives as long as the connection,
+// so values mapped to it are scoped to the connection
+type WSConn struct {
+	*Context
+	conn *websocket.Conn
+	done chan struct{}
+	once sync.Once
+}
+
+var wsConnType = reflect.TypeOf((*WSConn)(nil))
+
+// ReadMessage read a text or binary message
+//
+//	@return messageType WSTextMessage or WSBinaryMessage
+//	@return data
+//	@return err *WSCloseError if closed by client
+func (w *WSConn) ReadMessage() (messageType int, data []byte, err error) {
+	return w.conn.ReadMessage()
+}
+
+// WriteMessage write a text or binary message
+//
+//	@param messageType WSTextMessage or WSBinaryMessage
+//	@param data
+//
+//	@return error
+func (w *WSConn) WriteMessage(messageType int, data []byte) error {
+	return w.conn.WriteMessage(messageType, data)
+}
+
+// ReadJSON read a message and unmarshal it to v
+//
+//	@param v pointer
+//
+//	@return error
+func (w *WSConn) ReadJSON(v any) error {
+	_, data, err := w.conn.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// WriteJSON marshal v and write it as a text message
+//
+//	@param v
+//
+//	@return error
+func (w *WSConn) WriteJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return w.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// ReadText read a message as string
+//
+//	@return string
+//	@return error
+func (w *WSConn) ReadText() (string, error) {
+	_, data, err := w.conn.ReadMessage()
+	return string(data), err
+}
+
+// WriteText write a text message
+//
+//	@param text
+//
+//	@return error
+func (w *WSConn) WriteText(text string) error {
+	return w.conn.WriteMessage(websocket.TextMessage, []byte(text))
+}
+
+// Done closed when the connection is closed, e.g. on server shutdown
+//
+//	@return <-chan struct{}
+func (w *WSConn) Done() <-chan struct{} {
+	return w.done
+}
+
+// Close send a close frame and close the connection
+//
+//	@param code WSCloseNormal...
+//	@param reason
+//
+//	@return error
+func (w *WSConn) Close(code int, reason string) error {
+	var err error
+	w.once.Do(func() {
+		close(w.done)
+		err = w.conn.Close(code, reason)
+	})
+	return err
+}
+
+// keepalive ping the client every interval, the connection is closed if no pong received in 2 intervals
+func (w *WSConn) keepalive(interval time.Duration) {
+	_ = w.conn.SetReadDeadline(time.Now().Add(2 * interval))
+	w.conn.SetPongHandler(func(string) error {
+		return w.conn.SetReadDeadline(time.Now().Add(2 * interval))
+	})
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			if err := w.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(interval)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// SetWSCheckOrigin set the func checking Origin of websocket handshakes. by default requests without Origin,
+// from the same host or from origins allowed by server.cors are accepted, allowAllOrigins doesn't count
+//
+//	@param f returns false to reject the handshake with 403
+func (b *KApi) SetWSCheckOrigin(f func(r *http.Request) bool) {
+	b.wsCheckOrigin = f
+}
+
+// checkOrigin the default origin check of websocket handshakes
+func (b *KApi) checkOrigin(r *http.Request) bool {
+	if websocket.SameOrigin(r) {
+		return true
+	}
+	c := b.option.corsConfig.Load()
+	return c != nil && c.AllowOrigin(r.Header.Get("Origin"))
+}
+
+// upgrade upgrade the request to websocket and track the connection for shutdown
+func (b *KApi) upgrade(c *Context) (*WSConn, error) {
+	checkOrigin := b.wsCheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = b.checkOrigin
+	}
+	conn, err := websocket.Upgrade(c.Writer, c.Request, checkOrigin)
+	if err != nil {
+		return nil, err
+	}
+	if timeout := b.option.Server.WSWriteTimeout; timeout > 0 {
+		conn.SetWriteTimeout(time.Duration(timeout) * time.Second)
+	}
+	ws := &WSConn{Context: c, conn: conn, done: make(chan struct{})}
+	b.wsConns.Store(ws, struct{}{})
+	interval := time.Duration(b.option.Server.WSPingInterval) * time.Second
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	go ws.keepalive(interval)
+	return ws, nil
+}
+
+// release close the connection after the controller method returned
+func (b *KApi) release(ws *WSConn) {
+	_ = ws.Close(websocket.CloseNormalClosure, "")
+	b.wsConns.Delete(ws)
+}
+
+// closeWebSockets tell all clients the server is going away
+func (b *KApi) closeWebSockets() {
+	b.wsConns.Range(func(key, value any) bool {
+		_ = key.(*WSConn).Close(websocket.CloseGoingAway, "server shutdown")
+		return true
+	})
+}
@@ -0,0 +1,45 @@
+package kapi
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	allowAll := CorsConfig{AllowAllOrigins: true}
+	listed := CorsConfig{AllowOrigins: []string{"https://app.example.com"}}
+	wildcard := CorsConfig{AllowOrigins: []string{"https://*.example.com"}, AllowWildcard: true}
+	byFunc := CorsConfig{AllowOriginFunc: func(origin string) bool { return origin == "https://func.example.com" }}
+	tests := []struct {
+		name   string
+		cors   CorsConfig
+		origin string
+		want   bool
+	}{
+		{"no origin", allowAll, "", true},
+		{"same host", allowAll, "http://api.example.com", true},
+		{"same host https", listed, "https://api.example.com", true},
+		{"cross origin of allow all", allowAll, "https://evil.com", false},
+		{"listed", listed, "https://app.example.com", true},
+		{"listed case", listed, "https://APP.example.com", true},
+		{"not listed", listed, "https://evil.com", false},
+		{"wildcard", wildcard, "https://app.example.com", true},
+		{"not wildcard", wildcard, "https://example.com.evil.com", false},
+		{"func", byFunc, "https://func.example.com", true},
+		{"not func", byFunc, "https://evil.com", false},
+		{"null", listed, "null", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &KApi{option: &Option{}}
+			k.option.setCors(tt.cors)
+			r := httptest.NewRequest("GET", "http://api.example.com/ws", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if got := k.checkOrigin(r); got != tt.want {
+				t.Errorf("checkOrigin(%s) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}